@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Request signature headers, set by the pinger.
+const (
+	authKeyHeader       = "X-Auth-Key"
+	authTimestampHeader = "X-Auth-Timestamp"
+	authNonceHeader     = "X-Auth-Nonce"
+	authSignatureHeader = "X-Auth-Signature"
+)
+
+const (
+	defaultAuthWindow = 5 * time.Minute // allowed clock skew and replay protection period
+	maxAuthBodySize   = 10 << 20
+)
+
+// authKeys are the secrets by key ID. Several keys are accepted at once, so a key
+// is rotated by adding the new one, switching the pinger to it and removing the old one.
+type authKeys map[string][]byte
+
+// parseAuthKeys parses "id1:secret1,id2:secret2".
+func parseAuthKeys(s string) (authKeys, error) {
+	keys := authKeys{}
+	for _, item := range strings.Split(s, ",") {
+		id, secret, ok := strings.Cut(strings.TrimSpace(item), ":")
+		if !ok || id == "" || secret == "" {
+			return nil, errors.New(`want "id:secret" list`)
+		}
+		keys[id] = []byte(secret)
+	}
+	return keys, nil
+}
+
+// String returns the key IDs, the secrets are masked.
+func (keys authKeys) String() string {
+	ids := slices.Sorted(maps.Keys(keys))
+	for i := range ids {
+		ids[i] += ":xxxxx"
+	}
+	return strings.Join(ids, ",")
+}
+
+// Set replaces the keys with the parsed ones, empty s removes all the keys.
+func (keys authKeys) Set(s string) error {
+	parsed := authKeys{}
+	if s != "" {
+		var err error
+		if parsed, err = parseAuthKeys(s); err != nil {
+			return err
+		}
+	}
+	clear(keys)
+	maps.Copy(keys, parsed)
+	return nil
+}
+
+// authVerifier checks the HMAC-SHA256 signatures of the requests. The timestamp
+// of a request must be within the window and its nonce must not have been seen
+// during the window, so a captured request can't be replayed.
+type authVerifier struct {
+	keys   authKeys
+	window time.Duration
+	now    func() time.Time
+
+	mu        sync.Mutex
+	nonces    map[string]time.Time // expiration by key ID and nonce
+	lastPrune time.Time
+}
+
+func newAuthVerifier(keys authKeys, window time.Duration) *authVerifier {
+	return &authVerifier{
+		keys:   keys,
+		window: window,
+		now:    time.Now,
+		nonces: map[string]time.Time{},
+	}
+}
+
+// Middleware rejects the requests that are not signed with one of the keys.
+// Without keys all requests are passed.
+func (v *authVerifier) Middleware(h http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if len(v.keys) == 0 {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		x := newHandlerHelper(w, r, "Auth")
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxAuthBodySize))
+		if err != nil {
+			x.Log().Debug("can't read body", "error", err)
+			x.WriteError(errBadRequest)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		if reason := v.verify(r, body); reason != "" {
+			x.Log().Warn("request rejected", "reason", reason, "key", r.Header.Get(authKeyHeader))
+			authRejected.WithLabelValues(reason).Inc()
+			x.WriteError(errUnauthorized)
+			return
+		}
+
+		h.ServeHTTP(w, r)
+	}
+}
+
+// verify returns the reason to reject the request, empty if it is valid.
+func (v *authVerifier) verify(r *http.Request, body []byte) string {
+	keyID := r.Header.Get(authKeyHeader)
+	ts := r.Header.Get(authTimestampHeader)
+	nonce := r.Header.Get(authNonceHeader)
+	sig := r.Header.Get(authSignatureHeader)
+	if keyID == "" || ts == "" || nonce == "" || sig == "" {
+		return "unsigned"
+	}
+
+	secret, ok := v.keys[keyID]
+	if !ok {
+		return "unknown_key"
+	}
+
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return "bad_timestamp"
+	}
+	now := v.now()
+	if d := now.Sub(time.Unix(sec, 0)); d > v.window || d < -v.window {
+		return "expired"
+	}
+
+	got, err := hex.DecodeString(sig)
+	if err != nil || !hmac.Equal(got, authSignature(secret, r.Method, r.URL.RequestURI(), ts, nonce, body)) {
+		return "bad_signature"
+	}
+
+	// the nonce is checked last, so that forged requests do not fill the cache
+	if !v.useNonce(keyID+":"+nonce, now) {
+		return "replayed"
+	}
+
+	return ""
+}
+
+// useNonce remembers the nonce for the replay protection period, false if it is already used.
+func (v *authVerifier) useNonce(nonce string, now time.Time) bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if now.Sub(v.lastPrune) > v.window {
+		for n, exp := range v.nonces {
+			if now.After(exp) {
+				delete(v.nonces, n)
+			}
+		}
+		v.lastPrune = now
+	}
+
+	if exp, ok := v.nonces[nonce]; ok && !now.After(exp) {
+		return false
+	}
+	// a request with the timestamp in the future is valid up to 2 windows
+	v.nonces[nonce] = now.Add(2 * v.window)
+	return true
+}
+
+// authSignature is HMAC-SHA256 of method, URI, timestamp, nonce and hex SHA-256
+// of the body, separated by new lines.
+func authSignature(secret []byte, method, uri, ts, nonce string, body []byte) []byte {
+	sum := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(method + "\n" + uri + "\n" + ts + "\n" + nonce + "\n" + hex.EncodeToString(sum[:])))
+	return mac.Sum(nil)
+}
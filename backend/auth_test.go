@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAuthVerifier(t *testing.T) {
+	keys, err := parseAuthKeys("old:secret1, new:secret2")
+	if err != nil || len(keys) != 2 {
+		t.Fatalf("parseAuthKeys() = %v, %v", keys, err)
+	}
+	for _, s := range []string{"", "id", "id:", ":secret", "a:b,"} {
+		if _, err := parseAuthKeys(s); err == nil {
+			t.Errorf("parseAuthKeys(%q) error = nil", s)
+		}
+	}
+
+	now := time.Unix(1700000000, 0)
+	v := newAuthVerifier(keys, defaultAuthWindow)
+	v.now = func() time.Time { return now }
+
+	var gotBody string
+	handler := v.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		gotBody = string(b)
+	}))
+
+	const body = `{"pingResults":[]}`
+	sign := func(key, secret string, ts time.Time, nonce string) http.Header {
+		h := http.Header{}
+		sec := strconv.FormatInt(ts.Unix(), 10)
+		h.Set(authKeyHeader, key)
+		h.Set(authTimestampHeader, sec)
+		h.Set(authNonceHeader, nonce)
+		h.Set(authSignatureHeader, hex.EncodeToString(
+			authSignature([]byte(secret), "POST", "/ping-results", sec, nonce, []byte(body))))
+		return h
+	}
+	do := func(h http.Header) int {
+		r := httptest.NewRequest("POST", "/ping-results", strings.NewReader(body))
+		for k := range h {
+			r.Header.Set(k, h.Get(k))
+		}
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w.Code
+	}
+
+	// оба ключа принимаются во время ротации, тело доступно обработчику
+	if code := do(sign("old", "secret1", now, "n1")); code != 200 || gotBody != body {
+		t.Errorf("old key: status = %d, body = %q", code, gotBody)
+	}
+	if code := do(sign("new", "secret2", now.Add(-time.Minute), "n2")); code != 200 {
+		t.Errorf("new key: status = %d, want 200", code)
+	}
+
+	replayed := sign("old", "secret1", now, "n3")
+	bad := sign("old", "secret2", now, "n4")
+	tests := []struct {
+		name   string
+		header http.Header
+	}{
+		{"unsigned", http.Header{}},
+		{"unknown key", sign("other", "secret1", now, "n5")},
+		{"bad signature", bad},
+		{"expired", sign("old", "secret1", now.Add(-2*defaultAuthWindow), "n6")},
+		{"future", sign("old", "secret1", now.Add(2*defaultAuthWindow), "n7")},
+		{"replayed", replayed},
+	}
+	do(replayed)
+	for _, tt := range tests {
+		if code := do(tt.header); code != http.StatusUnauthorized {
+			t.Errorf("%s: status = %d, want 401", tt.name, code)
+		}
+	}
+
+	// неверная подпись не занимает nonce
+	if code := do(sign("old", "secret1", now, "n4")); code != 200 {
+		t.Errorf("nonce of rejected request: status = %d, want 200", code)
+	}
+
+	// после окна nonce забывается, но запрос с ним уже просрочен
+	now = now.Add(3 * defaultAuthWindow)
+	if code := do(replayed); code != http.StatusUnauthorized {
+		t.Errorf("old replayed: status = %d, want 401", code)
+	}
+	if code := do(sign("old", "secret1", now, "n8")); code != 200 {
+		t.Errorf("after window: status = %d, want 200", code)
+	}
+	if len(v.nonces) != 1 {
+		t.Errorf("%d nonces remembered, want 1", len(v.nonces))
+	}
+}
@@ -2,15 +2,36 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"log/slog"
+	"maps"
+	"math/rand/v2"
 	"slices"
+	"strconv"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
+// unknownHostReloadInterval is the min interval of the cache reloads caused by
+// the results of unknown hosts.
+const unknownHostReloadInterval = 10 * time.Second
+
 type cacheRepo interface {
 	GetHosts(ctx context.Context) ([]Host, error)
-	GetLastSuccessPingResults(ctx context.Context) ([]PingResult, error)
-	AddPingResults(ctx context.Context, results []PingResult) error
+	GetHostStatuses(ctx context.Context) ([]HostStatus, error)
+	AddPingResults(ctx context.Context, results []PingResult, changes []IPChange) error
+	GetLastAddrs(ctx context.Context) (map[int]string, error)
+	AddHost(ctx context.Context, host Host) (Host, error)
+	UpdateHost(ctx context.Context, id int, patch HostPatch) (Host, error)
+	DeleteHost(ctx context.Context, id int) error
+}
+
+type lastProbe struct {
+	Time     time.Time
+	Success  bool
+	Failures int // consecutive failed probes
 }
 
 type cache struct {
@@ -18,10 +39,41 @@ type cache struct {
 	mu    sync.Mutex
 	data  []PingResult
 	index map[int]int
+	addrs map[int]string    // last resolved address of the host
+	last  map[int]lastProbe // last probe of the host, successful or not
+
+	// the hosts deleted through the cache, their results are dropped without reload
+	deleted map[int]bool
+	// the last reload caused by the results of an unknown host
+	unknownReload time.Time
+
+	// the updates of data are published as "ping-result" events
+	stream *streamBroker
+
+	// the version of data for ETag, prefixed with the cache instance so that
+	// the versions of a restarted backend are not mixed up
+	instance       string
+	resultsVersion atomic.Uint64
+}
+
+func NewCache(repo cacheRepo, maxSubscribers int) *cache {
+	return &cache{
+		repo:     repo,
+		last:     map[int]lastProbe{},
+		deleted:  map[int]bool{},
+		stream:   newStreamBroker(maxSubscribers),
+		instance: strconv.FormatUint(rand.Uint64(), 36),
+	}
 }
 
-func NewCache(repo cacheRepo) *cache {
-	return &cache{repo: repo}
+// PingResultsETag returns the ETag of the last successful results. It must be
+// taken before the results, then the results are not older than the ETag.
+func (ca *cache) PingResultsETag() string {
+	return fmt.Sprintf(`W/"%s-r%d"`, ca.instance, ca.resultsVersion.Load())
+}
+
+func (ca *cache) GetHosts(ctx context.Context) ([]Host, error) {
+	return ca.repo.GetHosts(ctx)
 }
 
 func (ca *cache) Init(ctx context.Context) error {
@@ -38,19 +90,45 @@ func (ca *cache) Init(ctx context.Context) error {
 		index[host.ID] = i
 	}
 
-	results, err := ca.repo.GetLastSuccessPingResults(ctx)
+	statuses, err := ca.repo.GetHostStatuses(ctx)
 	if err != nil {
 		return err
 	}
 
-	for i := range results {
-		src := &results[i]
-		dst := &data[index[src.HostID]]
-		ca.copyPingResult(src, dst)
+	last := make(map[int]lastProbe, len(statuses))
+	for i := range statuses {
+		st := &statuses[i]
+		j, ok := index[st.HostID]
+		if !ok {
+			continue // added after the hosts were read
+		}
+		if !st.LastSuccess.Time.IsZero() {
+			ca.copyPingResult(&data[j], &st.LastSuccess)
+		}
+		last[st.HostID] = lastProbe{
+			Time:     st.LastAttempt,
+			Success:  st.LastAttemptSuccess,
+			Failures: st.ConsecutiveFailures,
+		}
 	}
 
+	addrs, err := ca.repo.GetLastAddrs(ctx)
+	if err != nil {
+		return err
+	}
+
+	reloaded := ca.index != nil
+	if reloaded {
+		// the hosts may have been changed by another backend instance
+		ca.resultsVersion.Add(1)
+	}
 	ca.data = data
 	ca.index = index
+	ca.addrs = addrs
+	ca.last = last
+	if reloaded {
+		ca.publishSnapshot(ctx)
+	}
 	return nil
 }
 
@@ -59,10 +137,19 @@ func (ca *cache) getLogger(ctx context.Context, op string) *slog.Logger {
 }
 
 func (ca *cache) copyPingResult(dst, src *PingResult) {
+	dst.Probe = src.Probe
 	dst.IP = src.IP
 	dst.Time = src.Time
 	dst.Rtt = src.Rtt
+	dst.StatusCode = src.StatusCode
+	dst.TLSHandshake = src.TLSHandshake
 	dst.Success = src.Success
+	dst.PacketsSent = src.PacketsSent
+	dst.PacketsRecv = src.PacketsRecv
+	dst.Loss = src.Loss
+	dst.MinRtt = src.MinRtt
+	dst.MaxRtt = src.MaxRtt
+	dst.StdDevRtt = src.StdDevRtt
 }
 
 func (ca *cache) GetLastSuccessPingResults(ctx context.Context) ([]PingResult, error) {
@@ -91,17 +178,304 @@ func (ca *cache) AddPingResults(ctx context.Context, results []PingResult) error
 		}
 	}
 
+	results, err := ca.knownHostResults(ctx, results)
+	if err != nil {
+		return err
+	}
+	if len(results) == 0 {
+		return nil
+	}
+
+	err = ca.addKnownResults(ctx, results)
+	if err == errUnknownHost {
+		// a cached host is deleted by another backend instance, the results are
+		// saved again without it, otherwise the pinger would retry the batch forever
+		log := ca.getLogger(ctx, "AddPingResults")
+		log.Warn("cached host is deleted from the database, reload cache")
+		ca.unknownReload = time.Now()
+		if err := ca.Init(ctx); err != nil {
+			return errInternalError
+		}
+		results, err = ca.knownHostResults(ctx, results)
+		if err != nil {
+			return err
+		}
+		if len(results) == 0 {
+			return nil
+		}
+		err = ca.addKnownResults(ctx, results)
+	}
+	if err == errUnknownHost {
+		return errInternalError
+	}
+	return err
+}
+
+// addKnownResults saves the results of the cached hosts and updates the cache.
+// The cache is changed after the results are saved, so that a failed batch can
+// be retried against the same state.
+func (ca *cache) addKnownResults(ctx context.Context, results []PingResult) error {
+	var changes []IPChange
+	addrs := map[int]string{}       // new addresses of the hosts
+	last := map[int]lastProbe{}     // new last probes of the hosts
+	updated := map[int]PingResult{} // new results by the data index
+
 	for i := range results {
 		src := &results[i]
-		j, ok := ca.index[src.HostID]
+		j := ca.index[src.HostID]
+		if src.Probe != probeDNS {
+			prev, ok := last[src.HostID]
+			if !ok {
+				prev, ok = ca.last[src.HostID]
+			}
+			if !ok || src.Time.After(prev.Time) {
+				failures := 0
+				if !src.Success {
+					failures = prev.Failures + 1
+				}
+				last[src.HostID] = lastProbe{Time: src.Time, Success: src.Success, Failures: failures}
+			}
+		}
+		if !src.Success {
+			// the cache keeps the last successful result only
+			continue
+		}
+		if src.Probe == probeDNS {
+			oldIP, ok := addrs[src.HostID]
+			if !ok {
+				oldIP = ca.addrs[src.HostID]
+			}
+			if oldIP != src.IP {
+				changes = append(changes, IPChange{
+					HostID: src.HostID,
+					OldIP:  oldIP,
+					NewIP:  src.IP,
+					Time:   src.Time,
+				})
+				addrs[src.HostID] = src.IP
+			}
+			continue
+		}
+		dst, ok := updated[j]
 		if !ok {
+			dst = ca.data[j]
+		}
+		ca.copyPingResult(&dst, src)
+		updated[j] = dst
+	}
+
+	if err := ca.repo.AddPingResults(ctx, results, changes); err != nil {
+		return err
+	}
+
+	maps.Copy(ca.addrs, addrs)
+	maps.Copy(ca.last, last)
+	if len(updated) > 0 {
+		ca.resultsVersion.Add(1)
+	}
+	for _, j := range slices.Sorted(maps.Keys(updated)) {
+		ca.data[j] = updated[j]
+		ca.publish(ctx, &ca.data[j])
+	}
+
+	return nil
+}
+
+// knownHostResults drops the results of unknown hosts. The hosts may have been
+// deleted, while the pinger does not know it yet, or added by another backend
+// instance, so the cache is reloaded before the results are dropped. The
+// reloads are limited by unknownHostReloadInterval, the results of the hosts
+// deleted through the cache do not cause them.
+func (ca *cache) knownHostResults(ctx context.Context, results []PingResult) ([]PingResult, error) {
+	known := func(res PingResult) bool {
+		_, ok := ca.index[res.HostID]
+		return ok
+	}
+
+	n := 0
+	reload := false
+	for _, res := range results {
+		if known(res) {
+			n++
+		} else if !ca.deleted[res.HostID] {
+			reload = true
+		}
+	}
+	if n == len(results) {
+		return results, nil
+	}
+
+	if reload && time.Since(ca.unknownReload) >= unknownHostReloadInterval {
+		ca.unknownReload = time.Now()
+		if err := ca.Init(ctx); err != nil {
+			return nil, errInternalError
+		}
+	}
+
+	filtered := make([]PingResult, 0, len(results))
+	for _, res := range results {
+		if known(res) {
+			filtered = append(filtered, res)
+		} else {
 			log := ca.getLogger(ctx, "AddPingResults")
-			log.Error("host id not found in cache", "host", *src)
-			return errBadRequest
+			log.Warn("host id not found in cache, result dropped", "result", res)
+			pingResultsRejected.WithLabelValues("unknown_host").Inc()
+		}
+	}
+	return filtered, nil
+}
+
+func (ca *cache) AddHost(ctx context.Context, host Host) (Host, error) {
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+
+	host, err := ca.repo.AddHost(ctx, host)
+	if err != nil {
+		return Host{}, err
+	}
+
+	if ca.data != nil {
+		ca.index[host.ID] = len(ca.data)
+		ca.data = append(ca.data, PingResult{HostID: host.ID, HostName: host.Name})
+		ca.publish(ctx, &ca.data[len(ca.data)-1])
+	}
+	ca.resultsVersion.Add(1)
+
+	return host, nil
+}
+
+func (ca *cache) UpdateHost(ctx context.Context, id int, patch HostPatch) (Host, error) {
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+
+	host, err := ca.repo.UpdateHost(ctx, id, patch)
+	if err != nil {
+		return Host{}, err
+	}
+
+	if ca.data != nil {
+		if i, ok := ca.index[id]; ok {
+			ca.data[i].HostName = host.Name
+			ca.publish(ctx, &ca.data[i])
 		}
-		dst := &ca.data[j]
-		ca.copyPingResult(dst, src)
 	}
+	ca.resultsVersion.Add(1)
 
-	return ca.repo.AddPingResults(ctx, results)
+	return host, nil
+}
+
+func (ca *cache) DeleteHost(ctx context.Context, id int) error {
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+
+	if err := ca.repo.DeleteHost(ctx, id); err != nil {
+		return err
+	}
+
+	if ca.data != nil {
+		if i, ok := ca.index[id]; ok {
+			ca.data = slices.Delete(ca.data, i, i+1)
+			delete(ca.index, id)
+			for j := i; j < len(ca.data); j++ {
+				ca.index[ca.data[j].HostID] = j
+			}
+			ca.publishHostDeleted(ctx, id)
+		}
+		delete(ca.addrs, id)
+	}
+	delete(ca.last, id)
+	ca.deleted[id] = true
+	ca.resultsVersion.Add(1)
+
+	return nil
+}
+
+// publish sends the cached result to the stream subscribers.
+func (ca *cache) publish(ctx context.Context, res *PingResult) {
+	data, err := json.Marshal(res)
+	if err != nil {
+		ca.getLogger(ctx, "publish").Error("can't marshal ping result", "error", err)
+		return
+	}
+	ca.stream.Publish("ping-result", data)
+}
+
+// publishHostDeleted sends the "host-deleted" event with the host ID to the stream subscribers.
+func (ca *cache) publishHostDeleted(ctx context.Context, id int) {
+	data, err := json.Marshal(struct {
+		HostID int `json:"host_id"`
+	}{id})
+	if err != nil {
+		ca.getLogger(ctx, "publishHostDeleted").Error("can't marshal host id", "error", err)
+		return
+	}
+	ca.stream.Publish("host-deleted", data)
+}
+
+// publishSnapshot sends all cached results to the stream subscribers, e.g.
+// after the hosts have been reloaded.
+func (ca *cache) publishSnapshot(ctx context.Context) {
+	data, err := json.Marshal(getPingResultsResponse{PingResults: ca.data})
+	if err != nil {
+		ca.getLogger(ctx, "publishSnapshot").Error("can't marshal ping results", "error", err)
+		return
+	}
+	ca.stream.Publish("snapshot", data)
+}
+
+// SubscribePingResults subscribes to the updates of the last successful results:
+// "ping-result" with the result of an updated or added host, "host-deleted" and
+// "snapshot" with all results after a reload. If the stream can't be resumed
+// from lastEventID, the backlog is the "snapshot" event.
+func (ca *cache) SubscribePingResults(ctx context.Context, lastEventID string) (*streamSubscription, error) {
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+
+	if ca.data == nil {
+		if err := ca.Init(ctx); err != nil {
+			return nil, errInternalError
+		}
+	}
+
+	// the lock keeps the snapshot consistent with the events published after it
+	sub, complete, err := ca.stream.Subscribe(lastEventID)
+	if err != nil || complete {
+		return sub, err
+	}
+
+	data, err := json.Marshal(getPingResultsResponse{PingResults: ca.data})
+	if err != nil {
+		sub.Close()
+		ca.getLogger(ctx, "SubscribePingResults").Error("can't marshal ping results", "error", err)
+		return nil, errInternalError
+	}
+	sub.Backlog = []streamEvent{{ID: ca.stream.LastID(), Type: "snapshot", Data: data}}
+
+	return sub, nil
+}
+
+// CloseStream disconnects the stream subscribers, e.g. on shutdown.
+func (ca *cache) CloseStream() {
+	ca.stream.Close()
+}
+
+// hostState is the last successful result of the host and the state of its last probe.
+type hostState struct {
+	PingResult
+	Probed   bool // the host has been probed, Up is known
+	Up       bool
+	Failures int // consecutive failed probes
+}
+
+// hostStates returns the states of the cached hosts, nothing if the cache is not loaded.
+func (ca *cache) hostStates() []hostState {
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+
+	states := make([]hostState, len(ca.data))
+	for i, res := range ca.data {
+		last, ok := ca.last[res.HostID]
+		states[i] = hostState{PingResult: res, Probed: ok, Up: last.Success, Failures: last.Failures}
+	}
+	return states
 }
@@ -0,0 +1,392 @@
+package main
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeRepo хранит хосты и результаты в памяти
+type fakeRepo struct {
+	hosts    []Host
+	results  []PingResult
+	changes  []IPChange
+	statuses map[int]HostStatus // host_status по хостам
+	err      error              // ошибка сохранения результатов
+	loads    int                // число чтений хостов
+}
+
+func (re *fakeRepo) GetHosts(ctx context.Context) ([]Host, error) {
+	re.loads++
+	return slices.Clone(re.hosts), nil
+}
+
+func (re *fakeRepo) GetHostStatuses(ctx context.Context) ([]HostStatus, error) {
+	var statuses []HostStatus
+	for _, id := range slices.Sorted(maps.Keys(re.statuses)) {
+		statuses = append(statuses, re.statuses[id])
+	}
+	return statuses, nil
+}
+
+func (re *fakeRepo) AddPingResults(ctx context.Context, results []PingResult, changes []IPChange) error {
+	if re.err != nil {
+		return re.err
+	}
+	for _, res := range results {
+		if !slices.ContainsFunc(re.hosts, func(h Host) bool { return h.ID == res.HostID }) {
+			return errUnknownHost
+		}
+	}
+	re.results = append(re.results, results...)
+	re.changes = append(re.changes, changes...)
+	if re.statuses == nil {
+		re.statuses = map[int]HostStatus{}
+	}
+	for _, upd := range hostStatusUpdates(results) {
+		st, ok := re.statuses[upd.HostID]
+		if !ok {
+			st = upd
+		}
+		re.statuses[upd.HostID] = mergeHostStatus(st, upd)
+	}
+	return nil
+}
+
+func (re *fakeRepo) GetLastAddrs(ctx context.Context) (map[int]string, error) {
+	addrs := map[int]string{}
+	for _, c := range re.changes {
+		addrs[c.HostID] = c.NewIP
+	}
+	return addrs, nil
+}
+
+func (re *fakeRepo) AddHost(ctx context.Context, host Host) (Host, error) {
+	for _, h := range re.hosts {
+		if h.Name == host.Name {
+			return Host{}, errConflict
+		}
+	}
+	host.ID = len(re.hosts) + 1
+	for _, h := range re.hosts {
+		host.ID = max(host.ID, h.ID+1)
+	}
+	re.hosts = append(re.hosts, host)
+	return host, nil
+}
+
+func (re *fakeRepo) UpdateHost(ctx context.Context, id int, patch HostPatch) (Host, error) {
+	for i := range re.hosts {
+		if re.hosts[i].ID == id {
+			patch.Apply(&re.hosts[i])
+			return re.hosts[i], nil
+		}
+	}
+	return Host{}, errNotFound
+}
+
+func (re *fakeRepo) DeleteHost(ctx context.Context, id int) error {
+	i := slices.IndexFunc(re.hosts, func(h Host) bool { return h.ID == id })
+	if i < 0 {
+		return errNotFound
+	}
+	re.hosts = slices.Delete(re.hosts, i, i+1)
+	return nil
+}
+
+// mergeHostStatus повторяет правила upsertHostStatusQuery для fakeRepo. Тест
+// проверяет эту копию и наличие правил в тексте запроса; сам SQL против
+// настоящей базы не проверяется. Батч, который не новее последней проверки
+// (например, повтор из spool), не меняет последнюю проверку и число ошибок.
+func mergeHostStatus(st, upd HostStatus) HostStatus {
+	if !upd.LastSuccess.Time.IsZero() && !upd.LastSuccess.Time.Before(st.LastSuccess.Time) {
+		st.LastSuccess = upd.LastSuccess
+	}
+	if !upd.LastFailure.IsZero() && !upd.LastFailure.Before(st.LastFailure) {
+		st.LastFailure = upd.LastFailure
+		st.LastFailureReason = upd.LastFailureReason
+	}
+	if upd.LastAttempt.After(st.LastAttempt) {
+		st.LastAttempt = upd.LastAttempt
+		st.LastAttemptSuccess = upd.LastAttemptSuccess
+		if upd.LastSuccess.Time.IsZero() {
+			st.ConsecutiveFailures += upd.ConsecutiveFailures
+		} else {
+			st.ConsecutiveFailures = upd.ConsecutiveFailures
+		}
+	}
+	return st
+}
+
+func TestCacheHosts(t *testing.T) {
+	ctx := context.Background()
+	repo := &fakeRepo{hosts: []Host{{ID: 1, Name: "db"}, {ID: 2, Name: "backend"}}}
+	ca := NewCache(repo, defaultStreamSubscribers)
+
+	mustResults := func(t *testing.T, want ...string) []PingResult {
+		t.Helper()
+		results, err := ca.GetLastSuccessPingResults(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, res := range results {
+			names = append(names, res.HostName)
+		}
+		if !slices.Equal(names, want) {
+			t.Fatalf("expected hosts %v, received %v", want, names)
+		}
+		return results
+	}
+
+	mustResults(t, "db", "backend")
+
+	frontend, err := ca.AddHost(ctx, Host{Name: "frontend"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mustResults(t, "db", "backend", "frontend")
+
+	if _, err := ca.AddHost(ctx, Host{Name: "db"}); err != errConflict {
+		t.Fatalf("expected conflict, received %v", err)
+	}
+
+	name := "http://frontend:4173/"
+	if _, err := ca.UpdateHost(ctx, frontend.ID, HostPatch{Name: &name}); err != nil {
+		t.Fatal(err)
+	}
+	mustResults(t, "db", "backend", name)
+
+	if err := ca.DeleteHost(ctx, 2); err != nil {
+		t.Fatal(err)
+	}
+	mustResults(t, "db", name)
+
+	// результаты удаленного хоста отбрасываются без перезагрузки кэша, остальные сохраняются
+	loads := repo.loads
+	now := time.Now()
+	err = ca.AddPingResults(ctx, []PingResult{
+		{HostID: 1, IP: "10.0.0.1", Time: now, Success: true},
+		{HostID: 2, IP: "10.0.0.2", Time: now, Success: true},
+		{HostID: frontend.ID, IP: "10.0.0.3", Time: now, Success: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(repo.results) != 2 || repo.loads != loads {
+		t.Errorf("expected 2 results saved without reload, received %d, %d reloads", len(repo.results), repo.loads-loads)
+	}
+	results := mustResults(t, "db", name)
+	if results[0].IP != "10.0.0.1" || results[1].IP != "10.0.0.3" {
+		t.Errorf("unexpected results %v", results)
+	}
+
+	// хост, добавленный в обход кэша, появляется после перезагрузки кэша
+	repo.hosts = append(repo.hosts, Host{ID: 10, Name: "nginx"})
+	if err := ca.AddPingResults(ctx, []PingResult{{HostID: 10, IP: "10.0.0.10", Time: now, Success: true}}); err != nil {
+		t.Fatal(err)
+	}
+	mustResults(t, "db", name, "nginx")
+
+	// неизвестный хост перезагружает кэш не чаще unknownHostReloadInterval
+	loads = repo.loads
+	if err := ca.AddPingResults(ctx, []PingResult{{HostID: 11, IP: "10.0.0.11", Time: now, Success: true}}); err != nil {
+		t.Fatal(err)
+	}
+	if repo.loads != loads {
+		t.Errorf("expected no reload, received %d", repo.loads-loads)
+	}
+
+	// хост, удаленный из базы в обход кэша, не блокирует сохранение остальных результатов
+	repo.hosts = slices.DeleteFunc(repo.hosts, func(h Host) bool { return h.ID == 10 })
+	saved := len(repo.results)
+	err = ca.AddPingResults(ctx, []PingResult{
+		{HostID: 1, IP: "10.0.0.1", Time: now.Add(time.Second), Success: true},
+		{HostID: 10, IP: "10.0.0.10", Time: now.Add(time.Second), Success: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(repo.results) != saved+1 {
+		t.Errorf("expected 1 result saved, received %d", len(repo.results)-saved)
+	}
+	mustResults(t, "db", name)
+}
+
+func TestCacheIPChanges(t *testing.T) {
+	ctx := context.Background()
+	repo := &fakeRepo{hosts: []Host{{ID: 1, Name: "db"}}}
+	ca := NewCache(repo, defaultStreamSubscribers)
+
+	now := time.Now()
+
+	// кэш не меняется, если результаты не сохранены, и повтор находит то же изменение
+	repo.err = errInternalError
+	err := ca.AddPingResults(ctx, []PingResult{
+		{HostID: 1, Probe: probeDNS, IP: "10.0.0.1", Time: now, Success: true},
+		{HostID: 1, IP: "10.0.0.1", Time: now, Success: true},
+	})
+	if err != errInternalError {
+		t.Fatalf("expected internal error, received %v", err)
+	}
+	if results, _ := ca.GetLastSuccessPingResults(ctx); results[0].IP != "" || len(ca.addrs) != 0 || len(ca.last) != 0 {
+		t.Errorf("cache changed by failed save: %v, %v, %v", results, ca.addrs, ca.last)
+	}
+	repo.err = nil
+
+	for _, ip := range []string{"10.0.0.1", "10.0.0.1", "10.0.0.2"} {
+		err := ca.AddPingResults(ctx, []PingResult{{HostID: 1, Probe: probeDNS, IP: ip, Time: now, Success: true}})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := []IPChange{
+		{HostID: 1, OldIP: "", NewIP: "10.0.0.1", Time: now},
+		{HostID: 1, OldIP: "10.0.0.1", NewIP: "10.0.0.2", Time: now},
+	}
+	if !slices.Equal(repo.changes, want) {
+		t.Errorf("expected changes %v, received %v", want, repo.changes)
+	}
+
+	// результаты dns не попадают в кэш последних результатов
+	results, _ := ca.GetLastSuccessPingResults(ctx)
+	if results[0].IP != "" {
+		t.Errorf("unexpected result %v", results[0])
+	}
+}
+
+func TestHostStatusUpdates(t *testing.T) {
+	now := time.Now()
+	updates := hostStatusUpdates([]PingResult{
+		{HostID: 2, Time: now.Add(2 * time.Second), Success: false, Reason: "timeout"},
+		{HostID: 1, Time: now, Success: false, Reason: "refused"},
+		{HostID: 2, Time: now, Success: true, IP: "10.0.0.2"},
+		{HostID: 2, Time: now.Add(time.Second), Success: false, Reason: "refused"},
+		{HostID: 3, Time: now, Probe: probeDNS, Success: true},
+	})
+
+	// dns не учитывается, результаты упорядочиваются по времени
+	want := []HostStatus{
+		{
+			HostID: 1, LastAttempt: now, LastFailure: now, LastFailureReason: "refused",
+			ConsecutiveFailures: 1,
+		},
+		{
+			HostID:      2,
+			LastSuccess: PingResult{HostID: 2, Time: now, Success: true, IP: "10.0.0.2"},
+			LastAttempt: now.Add(2 * time.Second), LastFailure: now.Add(2 * time.Second),
+			LastFailureReason: "timeout", ConsecutiveFailures: 2,
+		},
+	}
+	if !slices.Equal(updates, want) {
+		t.Errorf("hostStatusUpdates() = %+v, want %+v", updates, want)
+	}
+
+	q, values := upsertHostStatusQuery(updates)
+	if len(values) != 2*18 || !strings.Contains(q, "$36") || strings.Contains(q, "$37") {
+		t.Errorf("%d values for query %s", len(values), q)
+	}
+}
+
+func TestMergeHostStatus(t *testing.T) {
+	now := time.Now()
+	ok := func(d time.Duration) HostStatus {
+		return HostStatus{
+			HostID:      1,
+			LastSuccess: PingResult{HostID: 1, Time: now.Add(d), Success: true, IP: "10.0.0.1"},
+			LastAttempt: now.Add(d), LastAttemptSuccess: true,
+		}
+	}
+	failed := func(d time.Duration, failures int) HostStatus {
+		return HostStatus{
+			HostID: 1, LastAttempt: now.Add(d), LastFailure: now.Add(d), LastFailureReason: "timeout",
+			ConsecutiveFailures: failures,
+		}
+	}
+	withFailures := func(st HostStatus, upd HostStatus) HostStatus {
+		st.LastAttempt, st.LastAttemptSuccess = upd.LastAttempt, false
+		st.LastFailure, st.LastFailureReason = upd.LastFailure, upd.LastFailureReason
+		st.ConsecutiveFailures = upd.ConsecutiveFailures
+		return st
+	}
+
+	tests := []struct {
+		name    string
+		st, upd HostStatus
+		want    HostStatus
+	}{
+		{"newer failures are added", failed(0, 2), failed(time.Second, 3), failed(time.Second, 5)},
+		{"newer success resets failures", failed(0, 2), ok(time.Second), func() HostStatus {
+			st := ok(time.Second)
+			st.LastFailure, st.LastFailureReason = now, "timeout"
+			return st
+		}()},
+		// повтор партии из spool не меняет число ошибок
+		{"replayed failures", failed(time.Second, 2), failed(time.Second, 2), failed(time.Second, 2)},
+		{"older failures", failed(time.Second, 2), failed(0, 1), failed(time.Second, 2)},
+		{"older success", failed(time.Second, 2), ok(0), func() HostStatus {
+			st := failed(time.Second, 2)
+			st.LastSuccess = ok(0).LastSuccess
+			return st
+		}()},
+		{"older success is kept", ok(time.Second), withFailures(ok(0), failed(2*time.Second, 1)),
+			withFailures(ok(time.Second), failed(2*time.Second, 1))},
+	}
+	for _, tt := range tests {
+		if got := mergeHostStatus(tt.st, tt.upd); got != tt.want {
+			t.Errorf("%s: mergeHostStatus() = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+
+	// запрос использует те же правила
+	q, _ := upsertHostStatusQuery([]HostStatus{failed(0, 1)})
+	for _, rule := range []string{
+		"WHEN EXCLUDED.attempt_time <= s.attempt_time THEN s.consecutive_failures",
+		"WHEN EXCLUDED.success_time IS NOT NULL THEN EXCLUDED.consecutive_failures",
+		"CASE WHEN EXCLUDED.attempt_time > s.attempt_time\n\t\t\tTHEN EXCLUDED.attempt_success",
+	} {
+		if !strings.Contains(q, rule) {
+			t.Errorf("query does not contain %q", rule)
+		}
+	}
+}
+
+func TestCacheInitFromHostStatus(t *testing.T) {
+	ctx := context.Background()
+	repo := &fakeRepo{hosts: []Host{{ID: 1, Name: "db"}, {ID: 2, Name: "backend"}}}
+
+	now := time.Now()
+	batch := []PingResult{
+		{HostID: 1, IP: "10.0.0.1", Time: now, Rtt: time.Millisecond, Success: true},
+		{HostID: 1, Time: now.Add(time.Second), Reason: "timeout"},
+		{HostID: 1, Time: now.Add(2 * time.Second), Reason: "timeout"},
+	}
+	// повтор партии из spool после перезапуска не удваивает число ошибок
+	for range 2 {
+		if err := NewCache(repo, defaultStreamSubscribers).AddPingResults(ctx, batch); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// после перезапуска кэш заполняется из host_status
+	ca := NewCache(repo, defaultStreamSubscribers)
+	results, err := ca.GetLastSuccessPingResults(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results[0].IP != "10.0.0.1" || results[0].Rtt != time.Millisecond || !results[0].Time.Equal(now) {
+		t.Errorf("unexpected result %+v", results[0])
+	}
+	if !results[1].Time.IsZero() {
+		t.Errorf("unexpected result %+v", results[1])
+	}
+
+	states := ca.hostStates()
+	if !states[0].Probed || states[0].Up || states[0].Failures != 2 || states[1].Probed {
+		t.Errorf("unexpected states %+v", states)
+	}
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// config is the backend configuration. Every option is a flag "-name", a line
+// "name = value" of the config file and an environment variable NAME with
+// dashes replaced by underscores. The flags override the environment, the
+// environment overrides the file.
+type config struct {
+	Listen          string
+	ReadTimeout     time.Duration
+	WriteTimeout    time.Duration
+	ShutdownTimeout time.Duration
+
+	DBDSN       string // overrides the other DB options
+	DBHost      string
+	DBName      string
+	DBUser      string
+	DBPassword  string
+	DBUpTimeout time.Duration
+
+	LogLevel  slog.Level
+	PingHosts string // space separated
+	Retention retentionPolicy
+	AuthKeys  authKeys // empty - requests are not checked
+
+	AuthWindow        time.Duration
+	StreamSubscribers int
+	RollupDelay       time.Duration
+	PartitionsAhead   int // days
+
+	flags *flag.FlagSet
+}
+
+// configFileEnv is the environment variable with the config file path, if the flag is not given.
+const configFileEnv = "CONFIG"
+
+// secretOptions are masked when the config is logged.
+var secretOptions = map[string]bool{
+	"db-dsn":      true,
+	"db-password": true,
+	"auth-keys":   true,
+}
+
+func newConfigFlagSet(cfg *config) *flag.FlagSet {
+	fs := flag.NewFlagSet("backend", flag.ContinueOnError)
+	fs.String("config", "", "config `file` with \"name = value\" lines")
+
+	fs.StringVar(&cfg.Listen, "listen", ":8080", "http server address")
+	fs.DurationVar(&cfg.ReadTimeout, "read-timeout", 10*time.Second, "http request read timeout")
+	fs.DurationVar(&cfg.WriteTimeout, "write-timeout", 10*time.Second, "http response write timeout")
+	fs.DurationVar(&cfg.ShutdownTimeout, "shutdown-timeout", 30*time.Second, "graceful shutdown timeout")
+
+	fs.StringVar(&cfg.DBDSN, "db-dsn", "", "postgres connection string, overrides db-host, db-name, db-user and db-password")
+	fs.StringVar(&cfg.DBHost, "db-host", "db", "postgres host")
+	fs.StringVar(&cfg.DBName, "db-name", "monitoring", "postgres database")
+	fs.StringVar(&cfg.DBUser, "db-user", "postgres", "postgres user")
+	fs.StringVar(&cfg.DBPassword, "db-password", "postgres", "postgres password")
+	fs.DurationVar(&cfg.DBUpTimeout, "db-up-timeout", 30*time.Second, "how long to wait for the database on startup")
+
+	fs.TextVar(&cfg.LogLevel, "log-level", slog.LevelInfo, "log level: debug, info, warn or error")
+	fs.StringVar(&cfg.PingHosts, "ping-hosts", "", "space separated hosts added on startup")
+
+	cfg.Retention = defaultRetention
+	fs.DurationVar(&cfg.Retention.Interval, "retention-interval", defaultRetention.Interval, "retention job interval")
+	fs.DurationVar(&cfg.Retention.Raw, "retention-raw", defaultRetention.Raw, "raw ping results retention, 0 - forever")
+	fs.DurationVar(&cfg.Retention.Minute, "retention-1m", defaultRetention.Minute, "minute rollups retention, 0 - forever")
+	fs.DurationVar(&cfg.Retention.Hour, "retention-1h", defaultRetention.Hour, "hour rollups retention, 0 - forever")
+	fs.IntVar(&cfg.Retention.BatchSize, "retention-batch-size", defaultRetention.BatchSize, "rows deleted per statement")
+
+	cfg.AuthKeys = authKeys{}
+	fs.Var(cfg.AuthKeys, "auth-keys", "pinger request `keys` id1:secret1,id2:secret2")
+	fs.DurationVar(&cfg.AuthWindow, "auth-window", defaultAuthWindow, "allowed request clock skew and replay protection period")
+
+	fs.IntVar(&cfg.StreamSubscribers, "stream-max-subscribers", defaultStreamSubscribers, "ping results stream subscribers limit")
+	fs.DurationVar(&cfg.RollupDelay, "rollup-delay", defaultRollupDelay, "age of the results aggregated to the rollups")
+	fs.IntVar(&cfg.PartitionsAhead, "partitions-ahead", defaultPartitionsAhead, "days the ping_result partitions are created ahead")
+
+	return fs
+}
+
+// loadConfig reads the config from the flags of args, the environment and the
+// config file, and returns it with the arguments left after the flags. All
+// the invalid options are reported at once.
+func loadConfig(args []string, lookupEnv func(string) (string, bool)) (*config, []string, error) {
+	cfg := &config{}
+	fs := newConfigFlagSet(cfg)
+	cfg.flags = fs
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	byFlag := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { byFlag[f.Name] = true })
+
+	var errs []error
+	set := func(name, value, source string) {
+		if byFlag[name] {
+			return
+		}
+		f := fs.Lookup(name)
+		prev := f.Value.String()
+		if err := fs.Set(name, value); err != nil {
+			if !secretOptions[name] {
+				f.Value.Set(prev) // the std flag values are zeroed on error
+				name += "=" + strconv.Quote(value)
+			}
+			errs = append(errs, fmt.Errorf("%s: %s: %w", source, name, err))
+		}
+	}
+
+	path := fs.Lookup("config").Value.String()
+	if path == "" {
+		path, _ = lookupEnv(configFileEnv)
+	}
+	if path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		values, err := parseConfigFile(f)
+		f.Close()
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", path, err)
+		}
+		for _, kv := range values {
+			if fs.Lookup(kv[0]) == nil || kv[0] == "config" {
+				errs = append(errs, fmt.Errorf("%s: unknown option %s", path, kv[0]))
+				continue
+			}
+			set(kv[0], kv[1], path)
+		}
+	}
+
+	fs.VisitAll(func(f *flag.Flag) {
+		if f.Name == "config" {
+			return
+		}
+		env := envName(f.Name)
+		if v, ok := lookupEnv(env); ok {
+			set(f.Name, v, env)
+		} else if _, ok := lookupEnv("DEBUG"); ok && f.Name == "log-level" {
+			// DEBUG stands for LOG_LEVEL=debug, as docker-compose passes it
+			set(f.Name, "debug", "DEBUG")
+		}
+	})
+
+	errs = append(errs, cfg.validate()...)
+	if len(errs) > 0 {
+		return nil, nil, errors.Join(errs...)
+	}
+	return cfg, fs.Args(), nil
+}
+
+func (cfg *config) validate() []error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(cfg.Listen != "", "listen: must be set")
+	check(cfg.ReadTimeout > 0, "read-timeout: must be positive")
+	check(cfg.WriteTimeout > 0, "write-timeout: must be positive")
+	check(cfg.ShutdownTimeout > 0, "shutdown-timeout: must be positive")
+	check(cfg.DBDSN != "" || cfg.DBHost != "" && cfg.DBName != "" && cfg.DBUser != "",
+		"db-dsn or db-host, db-name and db-user must be set")
+	check(cfg.DBUpTimeout > 0, "db-up-timeout: must be positive")
+	check(cfg.Retention.Interval > 0, "retention-interval: must be positive")
+	check(cfg.Retention.Raw >= 0, "retention-raw: must not be negative")
+	check(cfg.Retention.Minute >= 0, "retention-1m: must not be negative")
+	check(cfg.Retention.Hour >= 0, "retention-1h: must not be negative")
+	check(cfg.Retention.BatchSize > 0, "retention-batch-size: must be positive")
+	check(cfg.AuthWindow > 0, "auth-window: must be positive")
+	check(cfg.StreamSubscribers > 0, "stream-max-subscribers: must be positive")
+	check(cfg.RollupDelay > 0, "rollup-delay: must be positive")
+	check(cfg.PartitionsAhead >= 1, "partitions-ahead: must be at least 1")
+	return errs
+}
+
+// DSN returns the postgres connection string.
+func (cfg *config) DSN() string {
+	if cfg.DBDSN != "" {
+		return cfg.DBDSN
+	}
+	return fmt.Sprintf("host=%s dbname=%s user=%s password=%s sslmode=disable",
+		quoteDSN(cfg.DBHost), quoteDSN(cfg.DBName), quoteDSN(cfg.DBUser), quoteDSN(cfg.DBPassword))
+}
+
+// LogValue is the effective config with the secrets masked.
+func (cfg *config) LogValue() slog.Value {
+	var attrs []slog.Attr
+	cfg.flags.VisitAll(func(f *flag.Flag) {
+		v := f.Value.String()
+		switch {
+		case f.Name == "config":
+			return
+		case f.Name == "db-dsn":
+			v = maskDSN(v)
+		case f.Name == "auth-keys":
+			// String shows the key IDs only
+		case secretOptions[f.Name] && v != "":
+			v = "xxxxx"
+		}
+		attrs = append(attrs, slog.String(f.Name, v))
+	})
+	return slog.GroupValue(attrs...)
+}
+
+// parseConfigFile reads "name = value" lines, the empty lines and the lines
+// starting with # are skipped.
+func parseConfigFile(r io.Reader) ([][2]string, error) {
+	var values [][2]string
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("line %d: want name = value", n)
+		}
+		values = append(values, [2]string{strings.TrimSpace(name), strings.TrimSpace(value)})
+	}
+	return values, sc.Err()
+}
+
+// envName is the environment variable of the option, "db-host" - DB_HOST.
+func envName(option string) string {
+	return strings.ToUpper(strings.ReplaceAll(option, "-", "_"))
+}
+
+// quoteDSN quotes a value of the key=value connection string.
+func quoteDSN(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s) + "'"
+}
+
+var dsnPasswordRe = regexp.MustCompile(`(password\s*=\s*)('(?:[^'\\]|\\.)*'|\S+)`)
+
+// maskDSN hides the password of the URL or key=value connection string.
+func maskDSN(dsn string) string {
+	if u, err := url.Parse(dsn); err == nil && u.Scheme != "" {
+		q := u.Query()
+		if q.Has("password") {
+			q.Set("password", "xxxxx")
+			u.RawQuery = q.Encode()
+		}
+		return u.Redacted()
+	}
+	return dsnPasswordRe.ReplaceAllString(dsn, "${1}xxxxx")
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "backend.conf")
+	err := os.WriteFile(path, []byte(`
+# комментарий
+listen = :9090
+read-timeout = 1s
+write-timeout = 2s
+db-host = file
+`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	env := map[string]string{
+		"CONFIG":        path,
+		"WRITE_TIMEOUT": "3s",
+		"DB_HOST":       "env",
+		"AUTH_KEYS":     "k1:s1,k2:s2",
+		"DEBUG":         "",
+	}
+	lookupEnv := func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	}
+
+	// флаги важнее переменных окружения, переменные окружения важнее файла
+	cfg, args, err := loadConfig([]string{"-db-host", "flag", "migrate", "up"}, lookupEnv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(args) != 2 || args[0] != "migrate" {
+		t.Errorf("args = %v", args)
+	}
+	if cfg.Listen != ":9090" || cfg.ReadTimeout != time.Second || cfg.WriteTimeout != 3*time.Second {
+		t.Errorf("listen = %q, read-timeout = %v, write-timeout = %v", cfg.Listen, cfg.ReadTimeout, cfg.WriteTimeout)
+	}
+	if cfg.DBHost != "flag" || cfg.ShutdownTimeout != 30*time.Second || cfg.Retention != defaultRetention {
+		t.Errorf("db-host = %q, shutdown-timeout = %v, retention = %v", cfg.DBHost, cfg.ShutdownTimeout, cfg.Retention)
+	}
+	if cfg.LogLevel != slog.LevelDebug || len(cfg.AuthKeys) != 2 {
+		t.Errorf("log-level = %v, %d auth keys", cfg.LogLevel, len(cfg.AuthKeys))
+	}
+
+	// секреты не попадают в лог
+	cfg.DBPassword = "p@ss"
+	logged := cfg.LogValue().String()
+	for _, secret := range []string{"p@ss", "s1", "s2"} {
+		if strings.Contains(logged, secret) {
+			t.Errorf("secret %q logged: %s", secret, logged)
+		}
+	}
+
+	// все ошибки сообщаются сразу
+	env = map[string]string{"READ_TIMEOUT": "-1s", "RETENTION_RAW": "x", "AUTH_KEYS": "topsecret"}
+	_, _, err = loadConfig([]string{"-shutdown-timeout", "0"}, lookupEnv)
+	if err == nil {
+		t.Fatal("error = nil")
+	}
+	for _, s := range []string{"read-timeout", "retention-raw", "auth-keys", "shutdown-timeout"} {
+		if !strings.Contains(err.Error(), s) {
+			t.Errorf("error %q does not mention %s", err, s)
+		}
+	}
+	if strings.Contains(err.Error(), "topsecret") {
+		t.Errorf("secret in error %q", err)
+	}
+
+	// DEBUG действует как LOG_LEVEL=debug, только если LOG_LEVEL не задана
+	env = map[string]string{"DEBUG": "", "LOG_LEVEL": "warn"}
+	cfg, _, err = loadConfig(nil, lookupEnv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.LogLevel != slog.LevelWarn {
+		t.Errorf("log-level = %v, want %v", cfg.LogLevel, slog.LevelWarn)
+	}
+}
+
+func TestConfigDSN(t *testing.T) {
+	cfg := &config{DBHost: "db", DBName: "monitoring", DBUser: "postgres", DBPassword: `it's`}
+	if got, want := cfg.DSN(), `host='db' dbname='monitoring' user='postgres' password='it\'s' sslmode=disable`; got != want {
+		t.Errorf("DSN() = %q, want %q", got, want)
+	}
+	cfg.DBDSN = "postgres://u:p@h/db"
+	if got := cfg.DSN(); got != cfg.DBDSN {
+		t.Errorf("DSN() = %q, want %q", got, cfg.DBDSN)
+	}
+
+	tests := map[string]string{
+		"postgres://u:secret@h/db":             "postgres://u:xxxxx@h/db",
+		"postgres://h/db?password=secret":      "postgres://h/db?password=xxxxx",
+		"host=h password=secret user=u":        "host=h password=xxxxx user=u",
+		`host=h password = 'se cr\'et' user=u`: "host=h password = xxxxx user=u",
+	}
+	for dsn, want := range tests {
+		if got := maskDSN(dsn); got != want {
+			t.Errorf("maskDSN(%q) = %q, want %q", dsn, got, want)
+		}
+	}
+}
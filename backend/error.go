@@ -12,5 +12,12 @@ func (e httpError) Error() string {
 var (
 	errInternalError = &httpError{500, "internal error"}
 	errBadRequest    = &httpError{400, "bad request"}
+	errUnauthorized  = &httpError{401, "unauthorized"}
 	errNotFound      = &httpError{404, "not found"}
+	errConflict      = &httpError{409, "conflict"}
+
+	errTimeoutOverInterval = &httpError{400, "timeout must not exceed interval"}
+	errUnknownHost         = &httpError{422, "unknown host"}
+
+	errTooManySubscribers = &httpError{503, "too many subscribers"}
 )
@@ -1,12 +1,20 @@
 package main
 
 import (
+	"compress/gzip"
 	"context"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
 type handlerHelper struct {
@@ -48,30 +56,175 @@ func (x handlerHelper) ReadBody(req any) error {
 	return nil
 }
 
+// QueryInt returns the integer query parameter or def if it is not set.
+func (x handlerHelper) QueryInt(name string, def int) (int, error) {
+	s := x.r.URL.Query().Get(name)
+	if s == "" {
+		return def, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		x.Log().Debug("can't parse query parameter", "name", name, "value", s)
+		return 0, errBadRequest
+	}
+	return v, nil
+}
+
+// QueryTime returns the RFC 3339 time query parameter or def if it is not set.
+func (x handlerHelper) QueryTime(name string, def time.Time) (time.Time, error) {
+	s := x.r.URL.Query().Get(name)
+	if s == "" {
+		return def, nil
+	}
+	v, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		x.Log().Debug("can't parse query parameter", "name", name, "value", s)
+		return time.Time{}, errBadRequest
+	}
+	return v, nil
+}
+
+// QueryDuration returns the duration query parameter (e.g. "1h30m") or def if it is not set.
+func (x handlerHelper) QueryDuration(name string, def time.Duration) (time.Duration, error) {
+	s := x.r.URL.Query().Get(name)
+	if s == "" {
+		return def, nil
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		x.Log().Debug("can't parse query parameter", "name", name, "value", s)
+		return 0, errBadRequest
+	}
+	return v, nil
+}
+
+// PathInt returns the integer path value.
+func (x handlerHelper) PathInt(name string) (int, error) {
+	s := x.r.PathValue(name)
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		x.Log().Debug("can't parse path value", "name", name, "value", s)
+		return 0, errNotFound
+	}
+	return v, nil
+}
+
 func (x handlerHelper) WriteError(err error) {
 	var httpError *httpError
 	if errors.As(err, &httpError) {
 		http.Error(x.w, httpError.Message, httpError.Status)
 	} else {
-		x.log.Warn("unhandled error expected", "error", err)
+		x.Log().Warn("unhandled error expected", "error", err)
 		http.Error(x.w, "internal error", 500)
 	}
 }
 
 func (x handlerHelper) WriteResponse(resp any) {
-	x.w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(x.w).Encode(resp); err != nil {
-		x.log.Error("can't write response", "error", err)
+	x.WriteStatusResponse(http.StatusOK, resp)
+}
+
+// WriteStatusResponse writes the JSON response, gzip-compressed if it is large
+// and the client accepts it.
+func (x handlerHelper) WriteStatusResponse(status int, resp any) {
+	data, err := json.Marshal(resp)
+	if err != nil {
+		x.Log().Error("can't marshal response", "error", err)
+		http.Error(x.w, "internal error", 500)
+		return
+	}
+	data = append(data, '\n')
+
+	h := x.w.Header()
+	h.Set("Content-Type", "application/json")
+
+	if len(data) < gzipMinSize {
+		x.w.WriteHeader(status)
+		if _, err := x.w.Write(data); err != nil {
+			x.Log().Error("can't write response", "error", err)
+		}
+		return
+	}
+
+	h.Add("Vary", "Accept-Encoding")
+	if !acceptsGzip(x.r) {
+		x.w.WriteHeader(status)
+		if _, err := x.w.Write(data); err != nil {
+			x.Log().Error("can't write response", "error", err)
+		}
+		return
+	}
+
+	h.Set("Content-Encoding", "gzip")
+	x.w.WriteHeader(status)
+
+	zw := gzipWriters.Get().(*gzip.Writer)
+	defer gzipWriters.Put(zw)
+	zw.Reset(x.w)
+	if _, err := zw.Write(data); err != nil {
+		x.Log().Error("can't write response", "error", err)
+		return
+	}
+	if err := zw.Close(); err != nil {
+		x.Log().Error("can't write response", "error", err)
+	}
+}
+
+// NotModified sets the ETag header and writes 304 Not Modified if the client
+// has the same version (If-None-Match).
+func (x handlerHelper) NotModified(etag string) bool {
+	x.w.Header().Set("ETag", etag)
+
+	// weak comparison, the gzip-compressed and plain responses have the same ETag
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, tag := range strings.Split(x.r.Header.Get("If-None-Match"), ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == etag || tag == "*" {
+			x.w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
+
+// gzipMinSize is the size of the response worth compressing
+const gzipMinSize = 1024
+
+var gzipWriters = sync.Pool{
+	New: func() any { return gzip.NewWriter(nil) },
+}
+
+func acceptsGzip(r *http.Request) bool {
+	for _, enc := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(enc, ";")
+		if strings.TrimSpace(name) != "gzip" {
+			continue
+		}
+		q, ok := strings.CutPrefix(strings.TrimSpace(params), "q=")
+		return !ok || strings.TrimSpace(q) != "0"
 	}
+	return false
 }
 
 type getHostsResponse struct {
 	Hosts []Host `json:"hosts"`
 }
 
-func getHostsHandler(s interface {
+type hostsGetter interface {
 	GetHosts(ctx context.Context) ([]Host, error)
-}) http.HandlerFunc {
+}
+
+// hostsETag returns the ETag of the hosts derived from their data, so that it
+// changes with any change of the host table, e.g. made by another backend instance.
+func hostsETag(hosts []Host) string {
+	h := sha256.New()
+	for _, host := range hosts {
+		fmt.Fprintf(h, "%d\x00%s\x00%d\x00%d\x00%d\x00%t\x00%t\n", host.ID, host.Name, host.Interval,
+			host.Timeout, host.Count, host.Aggregate, host.Enabled)
+	}
+	return fmt.Sprintf(`W/"h%x"`, h.Sum(nil)[:16])
+}
+
+func getHostsHandler(s hostsGetter) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		x := newHandlerHelper(w, r, "GetHost")
 
@@ -81,17 +234,108 @@ func getHostsHandler(s interface {
 			return
 		}
 
+		if x.NotModified(hostsETag(hosts)) {
+			return
+		}
+
 		x.WriteResponse(getHostsResponse{
 			Hosts: hosts,
 		})
 	}
 }
 
+type hostManager interface {
+	AddHost(ctx context.Context, host Host) (Host, error)
+	UpdateHost(ctx context.Context, id int, patch HostPatch) (Host, error)
+	DeleteHost(ctx context.Context, id int) error
+}
+
+func addHostHandler(s hostManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		x := newHandlerHelper(w, r, "AddHost")
+
+		var req HostPatch
+		if err := x.ReadBody(&req); err != nil {
+			x.WriteError(err)
+			return
+		}
+		if req.Name == nil {
+			x.WriteError(&httpError{http.StatusBadRequest, "host_name required"})
+			return
+		}
+		if err := req.Validate(); err != nil {
+			x.WriteError(&httpError{http.StatusBadRequest, err.Error()})
+			return
+		}
+
+		host := Host{Count: 1, Enabled: true}
+		req.Apply(&host)
+
+		host, err := s.AddHost(x.Ctx(), host)
+		if err != nil {
+			x.WriteError(err)
+			return
+		}
+
+		x.WriteStatusResponse(http.StatusCreated, host)
+	}
+}
+
+func updateHostHandler(s hostManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		x := newHandlerHelper(w, r, "UpdateHost")
+
+		id, err := x.PathInt("id")
+		if err != nil {
+			x.WriteError(err)
+			return
+		}
+
+		var req HostPatch
+		if err := x.ReadBody(&req); err != nil {
+			x.WriteError(err)
+			return
+		}
+		if err := req.Validate(); err != nil {
+			x.WriteError(&httpError{http.StatusBadRequest, err.Error()})
+			return
+		}
+
+		host, err := s.UpdateHost(x.Ctx(), id, req)
+		if err != nil {
+			x.WriteError(err)
+			return
+		}
+
+		x.WriteResponse(host)
+	}
+}
+
+func deleteHostHandler(s hostManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		x := newHandlerHelper(w, r, "DeleteHost")
+
+		id, err := x.PathInt("id")
+		if err != nil {
+			x.WriteError(err)
+			return
+		}
+
+		if err := s.DeleteHost(x.Ctx(), id); err != nil {
+			x.WriteError(err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
 type getPingResultsResponse struct {
 	PingResults []PingResult `json:"ping_results"`
 }
 
 type lastSuccessPingResultGetter interface {
+	PingResultsETag() string
 	GetLastSuccessPingResults(ctx context.Context) ([]PingResult, error)
 }
 
@@ -99,6 +343,10 @@ func getLastSuccessPingResultsHandler(s lastSuccessPingResultGetter) http.Handle
 	return func(w http.ResponseWriter, r *http.Request) {
 		x := newHandlerHelper(w, r, "GetLastSuccessPingResults")
 
+		if x.NotModified(s.PingResultsETag()) {
+			return
+		}
+
 		results, err := s.GetLastSuccessPingResults(r.Context())
 		if err != nil {
 			x.WriteError(err)
@@ -111,6 +359,81 @@ func getLastSuccessPingResultsHandler(s lastSuccessPingResultGetter) http.Handle
 	}
 }
 
+// streamHeartbeat keeps the idle stream connection open through proxies
+const streamHeartbeat = 15 * time.Second
+
+type pingResultsSubscriber interface {
+	SubscribePingResults(ctx context.Context, lastEventID string) (*streamSubscription, error)
+}
+
+// getPingResultsStreamHandler streams the updates of the last successful results
+// as Server-Sent Events: "snapshot" with all results when the stream starts,
+// then "ping-result" with a single result. The stream is resumed from the
+// Last-Event-ID of the reconnected client if the missed events are still kept.
+func getPingResultsStreamHandler(s pingResultsSubscriber) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		x := newHandlerHelper(w, r, "GetPingResultsStream")
+
+		sub, err := s.SubscribePingResults(x.Ctx(), r.Header.Get("Last-Event-ID"))
+		if err != nil {
+			x.WriteError(err)
+			return
+		}
+		defer sub.Close()
+
+		// the stream outlives the server write timeout
+		rc := http.NewResponseController(w)
+		if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+			x.Log().Warn("can't reset write deadline", "error", err)
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("X-Accel-Buffering", "no") // nginx
+		w.WriteHeader(http.StatusOK)
+
+		write := func(ev streamEvent) error {
+			_, err := fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", ev.ID, ev.Type, ev.Data)
+			return err
+		}
+
+		for _, ev := range sub.Backlog {
+			if err := write(ev); err != nil {
+				return
+			}
+		}
+		if err := rc.Flush(); err != nil {
+			x.Log().Error("can't flush stream", "error", err)
+			return
+		}
+
+		tk := time.NewTicker(streamHeartbeat)
+		defer tk.Stop()
+
+		for {
+			select {
+			case ev, ok := <-sub.Events:
+				if !ok {
+					// too slow or shutdown, the client reconnects
+					return
+				}
+				if err := write(ev); err != nil {
+					return
+				}
+			case <-tk.C:
+				if _, err := io.WriteString(w, ": heartbeat\n\n"); err != nil {
+					return
+				}
+			case <-r.Context().Done():
+				return
+			}
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
 type addPingResultRequest struct {
 	PingResults []PingResult `json:"ping_results"`
 }
@@ -129,7 +452,11 @@ func addPingResultHandler(s pingResultAdder) http.HandlerFunc {
 			return
 		}
 
+		pingResultBatches.Inc()
+		pingResultsReceived.Add(float64(len(req.PingResults)))
+
 		if err := s.AddPingResults(r.Context(), req.PingResults); err != nil {
+			pingResultsRejected.WithLabelValues("error").Add(float64(len(req.PingResults)))
 			x.WriteError(err)
 			return
 		}
@@ -137,3 +464,243 @@ func addPingResultHandler(s pingResultAdder) http.HandlerFunc {
 		w.WriteHeader(http.StatusCreated)
 	}
 }
+
+type getIPChangesResponse struct {
+	IPChanges []IPChange `json:"ip_changes"`
+}
+
+type ipChangesGetter interface {
+	GetIPChanges(ctx context.Context, hostID int, limit int) ([]IPChange, error)
+}
+
+func getIPChangesHandler(s ipChangesGetter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		x := newHandlerHelper(w, r, "GetIPChanges")
+
+		hostID, err := x.QueryInt("host_id", 0)
+		if err != nil {
+			x.WriteError(err)
+			return
+		}
+		limit, err := x.QueryInt("limit", 100)
+		if err != nil {
+			x.WriteError(err)
+			return
+		}
+		if hostID < 0 || limit <= 0 || limit > 1000 {
+			x.WriteError(errBadRequest)
+			return
+		}
+
+		changes, err := s.GetIPChanges(x.Ctx(), hostID, limit)
+		if err != nil {
+			x.WriteError(err)
+			return
+		}
+
+		x.WriteResponse(getIPChangesResponse{
+			IPChanges: changes,
+		})
+	}
+}
+
+type getHostPingResultsResponse struct {
+	PingResults []PingResult `json:"ping_results"`
+	NextCursor  string       `json:"next_cursor,omitempty"` // empty on the last page
+}
+
+type pingResultsGetter interface {
+	GetPingResults(ctx context.Context, hostID int, from, before time.Time, beforeID int64,
+		limit int) ([]PingResult, error)
+}
+
+// getHostPingResultsHandler returns the history of the host results, newest first,
+// in pages of limit results. The page is continued from the cursor returned with
+// the previous one.
+func getHostPingResultsHandler(s pingResultsGetter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		x := newHandlerHelper(w, r, "GetHostPingResults")
+
+		hostID, err := x.PathInt("id")
+		if err != nil {
+			x.WriteError(err)
+			return
+		}
+		from, err := x.QueryTime("from", time.Time{})
+		if err != nil {
+			x.WriteError(err)
+			return
+		}
+		to, err := x.QueryTime("to", time.Now())
+		if err != nil {
+			x.WriteError(err)
+			return
+		}
+		limit, err := x.QueryInt("limit", 100)
+		if err != nil {
+			x.WriteError(err)
+			return
+		}
+		if limit <= 0 || limit > 1000 {
+			x.WriteError(errBadRequest)
+			return
+		}
+
+		// the cursor is the last result of the previous page, to is exclusive
+		before, beforeID := to, int64(0)
+		if s := r.URL.Query().Get("cursor"); s != "" {
+			if before, beforeID, err = decodeCursor(s); err != nil {
+				x.Log().Debug("can't decode cursor", "cursor", s, "error", err)
+				x.WriteError(errBadRequest)
+				return
+			}
+		}
+
+		// one more result tells whether there is the next page
+		results, err := s.GetPingResults(x.Ctx(), hostID, from, before, beforeID, limit+1)
+		if err != nil {
+			x.WriteError(err)
+			return
+		}
+
+		resp := getHostPingResultsResponse{PingResults: results}
+		if len(results) > limit {
+			resp.PingResults = results[:limit]
+			last := results[limit-1]
+			resp.NextCursor = encodeCursor(last.Time, last.ID)
+		}
+
+		x.WriteResponse(resp)
+	}
+}
+
+func encodeCursor(tm time.Time, id int64) string {
+	s := strconv.FormatInt(tm.UnixNano(), 10) + ":" + strconv.FormatInt(id, 10)
+	return base64.RawURLEncoding.EncodeToString([]byte(s))
+}
+
+func decodeCursor(cursor string) (time.Time, int64, error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+	ns, id, ok := strings.Cut(string(b), ":")
+	if !ok {
+		return time.Time{}, 0, errors.New("invalid cursor")
+	}
+	n, err := strconv.ParseInt(ns, 10, 64)
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+	i, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+	return time.Unix(0, n).UTC(), i, nil
+}
+
+// maxStatsWindow limits the number of rows scanned by the stats query
+const maxStatsWindow = 7 * 24 * time.Hour
+
+type getStatsResponse struct {
+	Window time.Duration `json:"window"`
+	Stats  []HostStats   `json:"stats"`
+}
+
+type statsGetter interface {
+	GetStats(ctx context.Context, hostID int, since time.Time) ([]HostStats, error)
+}
+
+func getStatsHandler(s statsGetter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		x := newHandlerHelper(w, r, "GetStats")
+
+		hostID, err := x.QueryInt("host_id", 0)
+		if err != nil {
+			x.WriteError(err)
+			return
+		}
+		window, err := x.QueryDuration("window", time.Hour)
+		if err != nil {
+			x.WriteError(err)
+			return
+		}
+		if hostID < 0 || window <= 0 || window > maxStatsWindow {
+			x.WriteError(errBadRequest)
+			return
+		}
+
+		stats, err := s.GetStats(x.Ctx(), hostID, time.Now().Add(-window))
+		if err != nil {
+			x.WriteError(err)
+			return
+		}
+
+		x.WriteResponse(getStatsResponse{
+			Window: window,
+			Stats:  stats,
+		})
+	}
+}
+
+const (
+	minSeriesStep   = time.Second
+	maxSeriesPoints = 1000
+)
+
+type getSeriesResponse struct {
+	From   time.Time     `json:"from"`
+	To     time.Time     `json:"to"`
+	Step   time.Duration `json:"step"`
+	Points []SeriesPoint `json:"points"`
+}
+
+type seriesGetter interface {
+	GetSeries(ctx context.Context, hostID int, from, to time.Time, step time.Duration) ([]SeriesPoint, error)
+}
+
+// getSeriesHandler returns the host probes aggregated by step for charts,
+// the last hour by minutes by default.
+func getSeriesHandler(s seriesGetter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		x := newHandlerHelper(w, r, "GetSeries")
+
+		hostID, err := x.PathInt("id")
+		if err != nil {
+			x.WriteError(err)
+			return
+		}
+		to, err := x.QueryTime("to", time.Now())
+		if err != nil {
+			x.WriteError(err)
+			return
+		}
+		from, err := x.QueryTime("from", to.Add(-time.Hour))
+		if err != nil {
+			x.WriteError(err)
+			return
+		}
+		step, err := x.QueryDuration("step", time.Minute)
+		if err != nil {
+			x.WriteError(err)
+			return
+		}
+		if !from.Before(to) || step < minSeriesStep || to.Sub(from)/step > maxSeriesPoints {
+			x.WriteError(errBadRequest)
+			return
+		}
+
+		points, err := s.GetSeries(x.Ctx(), hostID, from, to, step)
+		if err != nil {
+			x.WriteError(err)
+			return
+		}
+
+		x.WriteResponse(getSeriesResponse{
+			From:   from,
+			To:     to,
+			Step:   step,
+			Points: points,
+		})
+	}
+}
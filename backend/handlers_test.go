@@ -0,0 +1,212 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestLastSuccessPingResultsETag(t *testing.T) {
+	re := &fakeRepo{}
+	for i := 1; i <= 50; i++ {
+		re.hosts = append(re.hosts, Host{ID: i, Name: fmt.Sprintf("host%d", i)})
+	}
+	ca := NewCache(re, defaultStreamSubscribers)
+	handler := getLastSuccessPingResultsHandler(ca)
+
+	get := func(etag, encoding string) *http.Response {
+		r := httptest.NewRequest("GET", "/pub/ping-results", nil)
+		if etag != "" {
+			r.Header.Set("If-None-Match", etag)
+		}
+		if encoding != "" {
+			r.Header.Set("Accept-Encoding", encoding)
+		}
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w.Result()
+	}
+
+	resp := get("", "gzip, deflate")
+	etag := resp.Header.Get("ETag")
+	if resp.StatusCode != 200 || etag == "" {
+		t.Fatalf("status = %d, ETag = %q", resp.StatusCode, etag)
+	}
+	if enc := resp.Header.Get("Content-Encoding"); enc != "gzip" {
+		t.Fatalf("Content-Encoding = %q, want gzip", enc)
+	}
+	zr, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var body getPingResultsResponse
+	if err := json.NewDecoder(zr).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if len(body.PingResults) != len(re.hosts) {
+		t.Errorf("got %d results, want %d", len(body.PingResults), len(re.hosts))
+	}
+
+	// без изменений - 304
+	if resp := get(etag, ""); resp.StatusCode != http.StatusNotModified {
+		t.Errorf("status = %d, want 304", resp.StatusCode)
+	}
+
+	// новый результат меняет версию, gzip не принимается
+	if err := ca.AddPingResults(context.Background(), []PingResult{{HostID: 1, Success: true}}); err != nil {
+		t.Fatal(err)
+	}
+	resp = get(etag, "gzip;q=0")
+	if resp.StatusCode != 200 || resp.Header.Get("ETag") == etag {
+		t.Errorf("status = %d, ETag = %q, want 200 with new ETag", resp.StatusCode, resp.Header.Get("ETag"))
+	}
+	if enc := resp.Header.Get("Content-Encoding"); enc != "" {
+		t.Errorf("Content-Encoding = %q, want none", enc)
+	}
+}
+
+func TestHostsETag(t *testing.T) {
+	re := &fakeRepo{hosts: []Host{{ID: 1, Name: "db"}}}
+	handler := getHostsHandler(NewCache(re, defaultStreamSubscribers))
+
+	get := func(etag string) *http.Response {
+		r := httptest.NewRequest("GET", "/hosts", nil)
+		if etag != "" {
+			r.Header.Set("If-None-Match", etag)
+		}
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w.Result()
+	}
+
+	etag := get("").Header.Get("ETag")
+	if resp := get(etag); resp.StatusCode != http.StatusNotModified {
+		t.Errorf("status = %d, want 304", resp.StatusCode)
+	}
+
+	// изменение в обход кэша (другим экземпляром backend) меняет ETag
+	re.hosts[0].Interval = time.Second
+	resp := get(etag)
+	if resp.StatusCode != 200 || resp.Header.Get("ETag") == etag {
+		t.Errorf("status = %d, ETag = %q, want 200 with new ETag", resp.StatusCode, resp.Header.Get("ETag"))
+	}
+}
+
+func TestHostPatchValidate(t *testing.T) {
+	d := func(d time.Duration) *time.Duration { return &d }
+	tests := []struct {
+		patch HostPatch
+		ok    bool
+	}{
+		{HostPatch{Interval: d(0), Timeout: d(0)}, true},
+		{HostPatch{Interval: d(time.Second), Timeout: d(time.Second)}, true},
+		{HostPatch{Interval: d(5 * time.Second)}, true},
+		{HostPatch{Interval: d(-time.Second)}, false},
+		// слишком частые проверки
+		{HostPatch{Interval: d(time.Nanosecond)}, false},
+		{HostPatch{Interval: d(time.Second), Timeout: d(2 * time.Second)}, false},
+		{HostPatch{Timeout: d(-time.Second)}, false},
+	}
+	for _, tt := range tests {
+		if err := tt.patch.Validate(); (err == nil) != tt.ok {
+			t.Errorf("Validate(interval %v, timeout %v) = %v", tt.patch.Interval, tt.patch.Timeout, err)
+		}
+	}
+}
+
+// fakeHistory отдает результаты хоста так же, как repo.GetPingResults:
+// по убыванию (time, id), строго до курсора
+type fakeHistory []PingResult
+
+func (h fakeHistory) GetPingResults(ctx context.Context, hostID int, from, before time.Time, beforeID int64,
+	limit int) ([]PingResult, error) {
+
+	var results []PingResult
+	for _, res := range h {
+		if res.Time.Before(from) || res.Time.After(before) {
+			continue
+		}
+		if res.Time.Equal(before) && (beforeID == 0 || res.ID >= beforeID) {
+			continue
+		}
+		if len(results) < limit {
+			results = append(results, res)
+		}
+	}
+	return results, nil
+}
+
+func TestCursor(t *testing.T) {
+	tm := time.Date(2025, 2, 1, 10, 30, 15, 123456000, time.UTC)
+	cursor := encodeCursor(tm, 42)
+	got, id, err := decodeCursor(cursor)
+	if err != nil || !got.Equal(tm) || id != 42 {
+		t.Errorf("decodeCursor(%q) = %v, %d, %v", cursor, got, id, err)
+	}
+
+	for _, s := range []string{"!", "MTIz", "YTo0Mg", "MTIzOmI"} {
+		if _, _, err := decodeCursor(s); err == nil {
+			t.Errorf("decodeCursor(%q): error = nil", s)
+		}
+	}
+}
+
+func TestHostPingResultsPages(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Second)
+	// результаты с одинаковым временем различаются по id
+	history := fakeHistory{
+		{ID: 2, IP: "a", Time: now.Add(-time.Second)},
+		{ID: 1, IP: "b", Time: now.Add(-time.Second)},
+		{ID: 4, IP: "c", Time: now.Add(-2 * time.Second)},
+		{ID: 3, IP: "d", Time: now.Add(-2 * time.Second)},
+		{ID: 5, IP: "e", Time: now.Add(-3 * time.Second)},
+	}
+	handler := getHostPingResultsHandler(history)
+
+	get := func(query string) (*http.Response, getHostPingResultsResponse) {
+		r := httptest.NewRequest("GET", "/pub/hosts/1/ping-results?"+query, nil)
+		r.SetPathValue("id", "1")
+		w := httptest.NewRecorder()
+		handler(w, r)
+		var body getHostPingResultsResponse
+		json.NewDecoder(w.Result().Body).Decode(&body)
+		return w.Result(), body
+	}
+
+	// страницы по 2 результата: a b, c d, e без курсора
+	var ips []string
+	cursor := ""
+	for pages := 0; ; pages++ {
+		resp, body := get("limit=2&cursor=" + cursor)
+		if resp.StatusCode != 200 || pages > 3 {
+			t.Fatalf("status = %d, pages = %d", resp.StatusCode, pages)
+		}
+		for _, res := range body.PingResults {
+			ips = append(ips, res.IP)
+		}
+		if body.NextCursor == "" {
+			break
+		}
+		cursor = body.NextCursor
+	}
+	if want := []string{"a", "b", "c", "d", "e"}; !slices.Equal(ips, want) {
+		t.Errorf("results %v, want %v", ips, want)
+	}
+
+	// последняя полная страница не дает курсор на пустую
+	if _, body := get("limit=5"); len(body.PingResults) != 5 || body.NextCursor != "" {
+		t.Errorf("%d results, next cursor %q", len(body.PingResults), body.NextCursor)
+	}
+
+	for _, query := range []string{"limit=0", "limit=1001", "limit=x", "from=yesterday", "to=x", "cursor=!"} {
+		if resp, _ := get(query); resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", query, resp.StatusCode)
+		}
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"math/rand/v2"
 	"net/http"
 	"runtime/debug"
+	"time"
 )
 
 type loggerContextID struct{}
@@ -31,8 +32,11 @@ func Logging(h http.Handler) http.HandlerFunc {
 		url := r.URL.String()
 		log.Debug("http request begin", "remoteAddr", r.RemoteAddr, "method", r.Method, "url", url)
 
+		start := time.Now()
+		status := http.StatusOK
 		w = newWriteHeaderHook(w, func(statusCode int) {
 			log.Debug("http request end", "statusCode", statusCode)
+			status = statusCode
 		})
 
 		ctx := ContextWithLogger(r.Context(), log)
@@ -41,7 +45,10 @@ func Logging(h http.Handler) http.HandlerFunc {
 		defer func() {
 			if p := recover(); p != nil {
 				log.Error("*** panic recovered ***", "panic", p, "stack", debug.Stack())
+				status = http.StatusInternalServerError
 			}
+			// ServeMux sets the pattern of r
+			observeHTTPRequest(r, status, start)
 		}()
 
 		h.ServeHTTP(w, r)
@@ -73,3 +80,8 @@ func (rw *writeHeaderHook) Write(b []byte) (int, error) {
 	rw.WriteHeader(http.StatusOK)
 	return rw.ResponseWriter.Write(b)
 }
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (rw *writeHeaderHook) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
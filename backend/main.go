@@ -4,87 +4,135 @@ import (
 	"cmp"
 	"context"
 	"database/sql"
-	"fmt"
+	"errors"
+	"flag"
 	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
 	_ "github.com/lib/pq"
-)
-
-const (
-	// TODO: to config
-	readTimeout     = 10 * time.Second
-	writeTimeout    = 10 * time.Second
-	shutdownTimeout = 30 * time.Second
-	dbHost          = "db"
-	dbName          = "monitoring"
-	dbUser          = "postgres"
-	dbPassword      = "postgres"
-	dbUpTimeout     = 30 * time.Second
-)
-
-var (
-	logLevel = slog.LevelInfo
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 func main() {
-	if _, ok := os.LookupEnv("DEBUG"); ok {
-		logLevel = slog.LevelDebug
+	cfg, args, err := loadConfig(os.Args[1:], os.LookupEnv)
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
 	}
-	os.Exit(run())
-}
+	if err != nil {
+		slog.Error("invalid config", "error", err)
+		os.Exit(2)
+	}
+	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: cfg.LogLevel})))
+	slog.Info("config", "config", cfg)
 
-func run() int {
-	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: logLevel})))
+	if len(args) > 0 && args[0] == "migrate" {
+		os.Exit(runMigrate(cfg, args[1:]))
+	}
+	if len(args) > 0 {
+		slog.Error("unexpected arguments", "args", args)
+		os.Exit(2)
+	}
+	os.Exit(run(cfg))
+}
 
-	// TODO: load config
+func run(cfg *config) int {
+	if len(cfg.AuthKeys) == 0 {
+		slog.Warn("auth-keys is not set, pinger requests are not checked")
+	}
 
-	db, err := openDB()
+	db, err := openDB(cfg.DSN())
 	if err != nil {
 		slog.Error("can't open database", "error", err)
 		return 1
 	}
 	defer db.Close()
 
-	slog.Info("wait database up...", "timeout", dbUpTimeout)
-	if err := waitDB(db, dbUpTimeout); err != nil {
+	slog.Info("wait database up...", "timeout", cfg.DBUpTimeout)
+	if err := waitDB(db, cfg.DBUpTimeout); err != nil {
 		slog.Error("database up timeout expired", "lastErr", err)
 		db.Close()
 		return 1
 	}
 
-	// TODO: migrations up
+	if err := migrateUp(context.Background(), db); err != nil {
+		slog.Error("can't migrate database", "error", err)
+		return 1
+	}
 
-	repo := NewRepo(db)
+	repo := NewRepo(db, cfg.RollupDelay)
 
-	if err := repo.AddHosts(context.Background(), getHostsFromEnv()); err != nil {
+	if err := ensurePartitions(context.Background(), repo, time.Now(), cfg.PartitionsAhead); err != nil {
+		slog.Warn("can't create partitions, results go to the default one", "error", err)
+	}
+
+	if err := repo.SeedHosts(context.Background(), splitHosts(cfg.PingHosts)); err != nil {
+		return 1
+	}
+	cache := NewCache(repo, cfg.StreamSubscribers)
+	// load the cache before serving, the host metrics are empty until then
+	if err := cache.Init(context.Background()); err != nil {
+		slog.Error("can't load cache", "error", err)
 		return 1
 	}
-	cache := NewCache(repo)
+	prometheus.MustRegister(hostCollector{cache})
+
+	// background jobs are stopped after the http server
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	var jobs sync.WaitGroup
+	jobs.Add(3)
+	go func() {
+		defer jobs.Done()
+		runRollup(jobsCtx, repo, rollupInterval, cfg.RollupDelay)
+	}()
+	go func() {
+		defer jobs.Done()
+		runRetention(jobsCtx, repo, cfg.Retention)
+	}()
+	go func() {
+		defer jobs.Done()
+		runPartitions(jobsCtx, repo, partitionInterval, cfg.PartitionsAhead)
+	}()
+	defer func() {
+		stopJobs()
+		jobs.Wait()
+	}()
 
 	mux := http.NewServeMux()
 
 	pong := func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("pong")) }
 
 	mux.HandleFunc("GET  /ping", pong)
-	mux.HandleFunc("GET  /hosts", getHostsHandler(repo))
-	mux.HandleFunc("POST /ping-results", addPingResultHandler(cache))
+	mux.Handle("GET  /metrics", promhttp.Handler())
+	verifier := newAuthVerifier(cfg.AuthKeys, cfg.AuthWindow)
+	mux.HandleFunc("GET  /hosts", verifier.Middleware(getHostsHandler(cache)))
+	mux.HandleFunc("POST /hosts", verifier.Middleware(addHostHandler(cache)))
+	mux.HandleFunc("PATCH /hosts/{id}", verifier.Middleware(updateHostHandler(cache)))
+	mux.HandleFunc("DELETE /hosts/{id}", verifier.Middleware(deleteHostHandler(cache)))
+	mux.HandleFunc("POST /ping-results", verifier.Middleware(addPingResultHandler(cache)))
 
 	mux.HandleFunc("GET  /pub/ping", pong)
-	mux.HandleFunc("GET  /pub/hosts", getHostsHandler(repo))
+	mux.HandleFunc("GET  /pub/hosts", getHostsHandler(cache))
 	mux.HandleFunc("GET  /pub/ping-results", getLastSuccessPingResultsHandler(cache))
+	mux.HandleFunc("GET  /pub/ping-results/stream", getPingResultsStreamHandler(cache))
+	mux.HandleFunc("GET  /pub/ip-changes", getIPChangesHandler(repo))
+	mux.HandleFunc("GET  /pub/hosts/{id}/ping-results", getHostPingResultsHandler(repo))
+	mux.HandleFunc("GET  /pub/hosts/{id}/series", getSeriesHandler(repo))
+	mux.HandleFunc("GET  /pub/stats", getStatsHandler(repo))
 
 	server := http.Server{
 		Handler:      Logging(mux),
-		Addr:         ":8080",
-		ReadTimeout:  readTimeout,
-		WriteTimeout: writeTimeout,
+		Addr:         cfg.Listen,
+		ReadTimeout:  cfg.ReadTimeout,
+		WriteTimeout: cfg.WriteTimeout,
 	}
+	server.RegisterOnShutdown(cache.CloseStream)
 
 	done := make(chan int)
 	go func() {
@@ -94,8 +142,8 @@ func run() int {
 		signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 		signal := <-c
 
-		slog.Info("shutdown by signal", "signal", signal, "timeout", shutdownTimeout)
-		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		slog.Info("shutdown by signal", "signal", signal, "timeout", cfg.ShutdownTimeout)
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
 		defer cancel()
 		if err := server.Shutdown(ctx); err != nil {
 			slog.Error("can't shutdown http server", "error", err)
@@ -113,10 +161,8 @@ func run() int {
 	return <-done
 }
 
-func openDB() (*sql.DB, error) {
-	connStr := fmt.Sprintf("host=%s dbname=%s user=%s password=%s sslmode=disable",
-		dbHost, dbName, dbUser, dbPassword)
-	db, err := sql.Open("postgres", connStr)
+func openDB(dsn string) (*sql.DB, error) {
+	db, err := sql.Open("postgres", dsn)
 	if err != nil {
 		return nil, err
 	}
@@ -155,8 +201,8 @@ func waitDB(db *sql.DB, timeout time.Duration) error {
 	}
 }
 
-func getHostsFromEnv() []string {
-	hosts := strings.Split(os.Getenv("PING_HOSTS"), " ")
+func splitHosts(s string) []string {
+	hosts := strings.Split(s, " ")
 
 	// rermove empty items
 	n := 0
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Prometheus metrics, served at GET /metrics.
+var (
+	httpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "backend_http_request_duration_seconds",
+		Help:    "Duration of HTTP requests.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route", "code"})
+
+	dbQueryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "backend_db_query_duration_seconds",
+		Help:    "Duration of database queries by repo operation.",
+		Buckets: []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+	}, []string{"op"})
+
+	pingResultBatches = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "backend_ping_result_batches_total",
+		Help: "Batches of ping results received from the pinger.",
+	})
+	pingResultsReceived = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "backend_ping_results_total",
+		Help: "Ping results received from the pinger.",
+	})
+	pingResultsRejected = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "backend_ping_results_rejected_total",
+		Help: "Ping results not stored: unknown_host or error.",
+	}, []string{"reason"})
+
+	authRejected = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "backend_auth_rejected_total",
+		Help: "Requests rejected by the signature check.",
+	}, []string{"reason"})
+
+	retentionRowsDeleted = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "backend_retention_rows_deleted_total",
+		Help: "Rows deleted by the retention job.",
+	}, []string{"table"})
+	retentionPartitionsDropped = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "backend_retention_partitions_dropped_total",
+		Help: "Partitions of ping_result dropped by the retention job.",
+	})
+	retentionDuration = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "backend_retention_duration_seconds",
+		Help: "Duration of the last purge of the table.",
+	}, []string{"table"})
+)
+
+// observeDBQuery records the duration of the repo operation started at start.
+func observeDBQuery(op string, start time.Time) {
+	dbQueryDuration.WithLabelValues(op).Observe(time.Since(start).Seconds())
+}
+
+// unobservedRoutes are the long-lived requests, their duration is not a latency.
+var unobservedRoutes = map[string]bool{
+	"GET /pub/ping-results/stream": true,
+}
+
+// observeHTTPRequest records the duration of the request served by ServeMux.
+func observeHTTPRequest(r *http.Request, statusCode int, start time.Time) {
+	route := "unmatched"
+	if r.Pattern != "" {
+		route = strings.Join(strings.Fields(r.Pattern), " ")
+	}
+	if unobservedRoutes[route] {
+		return
+	}
+	httpRequestDuration.WithLabelValues(r.Method, route, strconv.Itoa(statusCode)).
+		Observe(time.Since(start).Seconds())
+}
+
+var (
+	hostUpDesc = prometheus.NewDesc("backend_host_up",
+		"Whether the last probe of the host succeeded.", []string{"host_id", "host"}, nil)
+	hostLastRttDesc = prometheus.NewDesc("backend_host_last_rtt_seconds",
+		"RTT of the last successful probe of the host.", []string{"host_id", "host"}, nil)
+	hostLastSuccessDesc = prometheus.NewDesc("backend_host_last_success_timestamp_seconds",
+		"Time of the last successful probe of the host.", []string{"host_id", "host"}, nil)
+	hostFailuresDesc = prometheus.NewDesc("backend_host_consecutive_failures",
+		"Failed probes of the host since the last successful one.", []string{"host_id", "host"}, nil)
+)
+
+// hostCollector exports the state of the cached hosts, so the series of the
+// deleted hosts disappear with them.
+type hostCollector struct {
+	ca *cache
+}
+
+func (c hostCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- hostUpDesc
+	ch <- hostLastRttDesc
+	ch <- hostLastSuccessDesc
+	ch <- hostFailuresDesc
+}
+
+func (c hostCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, h := range c.ca.hostStates() {
+		id := strconv.Itoa(h.HostID)
+		if h.Probed {
+			ch <- prometheus.MustNewConstMetric(hostUpDesc, prometheus.GaugeValue, boolToFloat(h.Up), id, h.HostName)
+			ch <- prometheus.MustNewConstMetric(hostFailuresDesc, prometheus.GaugeValue, float64(h.Failures), id, h.HostName)
+		}
+		if !h.Time.IsZero() {
+			ch <- prometheus.MustNewConstMetric(hostLastRttDesc, prometheus.GaugeValue, h.Rtt.Seconds(), id, h.HostName)
+			ch <- prometheus.MustNewConstMetric(hostLastSuccessDesc, prometheus.GaugeValue,
+				float64(h.Time.UnixNano())/1e9, id, h.HostName)
+		}
+	}
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"regexp"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// migrationFiles are the schema migrations "NNNN_name.up.sql" and
+// "NNNN_name.down.sql", numbered from 1 without gaps.
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrationLockID is the key of the advisory lock held while the migrations
+// are applied, so the backend replicas started together do not race.
+const migrationLockID = 7285309154003125043
+
+var migrationFileRe = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+type migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// loadMigrations reads the migrations from fsys, sorted by version.
+func loadMigrations(fsys fs.FS) ([]migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*migration{}
+	for _, e := range entries {
+		m := migrationFileRe.FindStringSubmatch(e.Name())
+		if m == nil || e.IsDir() {
+			return nil, fmt.Errorf("unexpected migration file %q", e.Name())
+		}
+		version, err := strconv.Atoi(m[1])
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("invalid migration version %q", e.Name())
+		}
+
+		data, err := fs.ReadFile(fsys, e.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		mig := byVersion[version]
+		if mig == nil {
+			mig = &migration{Version: version, Name: m[2]}
+			byVersion[version] = mig
+		} else if mig.Name != m[2] {
+			return nil, fmt.Errorf("migration %d has names %q and %q", version, mig.Name, m[2])
+		}
+		if m[3] == "up" {
+			mig.Up = string(data)
+		} else {
+			mig.Down = string(data)
+		}
+	}
+
+	migrations := make([]migration, 0, len(byVersion))
+	for v := 1; v <= len(byVersion); v++ {
+		mig, ok := byVersion[v]
+		if !ok {
+			return nil, fmt.Errorf("migration %d is missing", v)
+		}
+		if mig.Up == "" || mig.Down == "" {
+			return nil, fmt.Errorf("migration %d must have up and down files", v)
+		}
+		migrations = append(migrations, *mig)
+	}
+	return migrations, nil
+}
+
+// embeddedMigrations returns the migrations built into the binary.
+func embeddedMigrations() ([]migration, error) {
+	fsys, err := fs.Sub(migrationFiles, "migrations")
+	if err != nil {
+		return nil, err
+	}
+	return loadMigrations(fsys)
+}
+
+// migrate applies the up or down migrations until the schema has the target
+// version, each migration in its own transaction. The database created by the
+// former db/init.sql is taken as the version 1, see isBaselineSchema.
+func migrate(ctx context.Context, db *sql.DB, migrations []migration, target int) error {
+	log := slog.With("op", "migrate")
+
+	if target < 0 || target > len(migrations) {
+		return fmt.Errorf("unknown schema version %d, latest is %d", target, len(migrations))
+	}
+
+	// the session level lock needs a single connection
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, migrationLockID); err != nil {
+		return fmt.Errorf("can't lock: %w", err)
+	}
+	defer func() {
+		if _, err := conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, migrationLockID); err != nil {
+			log.Warn("can't unlock", "error", err)
+		}
+	}()
+
+	version, err := schemaVersion(ctx, conn)
+	if err != nil {
+		return err
+	}
+	if version > len(migrations) {
+		return fmt.Errorf("schema version %d is newer than the latest known %d", version, len(migrations))
+	}
+
+	if version == 0 && len(migrations) > 0 {
+		baseline, err := isBaselineSchema(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if baseline {
+			_, err := conn.ExecContext(ctx,
+				`INSERT INTO schema_migrations (version, name, applied_at) VALUES ($1, $2, $3)`,
+				1, migrations[0].Name, time.Now().UTC())
+			if err != nil {
+				return err
+			}
+			version = 1
+			log.Info("existing schema taken as version 1")
+		}
+	}
+
+	for ; version < target; version++ {
+		if err := applyMigration(ctx, conn, migrations[version], true); err != nil {
+			return err
+		}
+	}
+	for ; version > target; version-- {
+		if err := applyMigration(ctx, conn, migrations[version-1], false); err != nil {
+			return err
+		}
+	}
+
+	log.Info("schema is up to date", "version", version)
+	return nil
+}
+
+// baselineColumns are the tables and columns of the former db/init.sql, the
+// version 1 of the schema, sorted.
+var baselineColumns = []string{
+	"host.host_id",
+	"host.host_name",
+	"ping_result.host_id",
+	"ping_result.id",
+	"ping_result.ip",
+	"ping_result.ping_rtt",
+	"ping_result.ping_time",
+	"ping_result.success",
+}
+
+// isBaselineSchema reports whether the database has the tables of the former
+// db/init.sql without schema_migrations. It is false for an empty database and
+// an error for any other schema, which must be migrated by hand.
+func isBaselineSchema(ctx context.Context, conn *sql.Conn) (bool, error) {
+	const q = `SELECT c.table_name || '.' || c.column_name
+	FROM information_schema.columns c
+	JOIN information_schema.tables t USING (table_schema, table_name)
+	WHERE c.table_schema = current_schema() AND t.table_type = 'BASE TABLE'
+		AND c.table_name <> 'schema_migrations';`
+
+	rows, err := conn.QueryContext(ctx, q)
+	if err != nil {
+		return false, fmt.Errorf("can't get schema: %w", err)
+	}
+	defer rows.Close()
+
+	var columns []string
+	for rows.Next() {
+		var col string
+		if err := rows.Scan(&col); err != nil {
+			return false, fmt.Errorf("can't get schema: %w", err)
+		}
+		columns = append(columns, col)
+	}
+	if err := rows.Err(); err != nil {
+		return false, fmt.Errorf("can't get schema: %w", err)
+	}
+
+	slices.Sort(columns)
+	switch {
+	case len(columns) == 0:
+		return false, nil
+	case slices.Equal(columns, baselineColumns):
+		return true, nil
+	default:
+		return false, fmt.Errorf("unknown schema without schema_migrations, columns %v", columns)
+	}
+}
+
+// schemaVersion creates schema_migrations if needed and returns the latest applied version.
+func schemaVersion(ctx context.Context, conn *sql.Conn) (int, error) {
+	_, err := conn.ExecContext(ctx, `
+CREATE TABLE IF NOT EXISTS schema_migrations (
+    version INT PRIMARY KEY,
+    name VARCHAR(128) NOT NULL,
+    applied_at TIMESTAMP NOT NULL
+)`)
+	if err != nil {
+		return 0, fmt.Errorf("can't create schema_migrations: %w", err)
+	}
+
+	var version int
+	err = conn.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version)
+	if err != nil {
+		return 0, fmt.Errorf("can't get schema version: %w", err)
+	}
+	return version, nil
+}
+
+func applyMigration(ctx context.Context, conn *sql.Conn, m migration, up bool) (err error) {
+	log := slog.With("op", "migrate", "version", m.Version, "name", m.Name, "up", up)
+	start := time.Now()
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			err = fmt.Errorf("migration %d_%s: %w", m.Version, m.Name, err)
+		}
+	}()
+
+	script := m.Down
+	if up {
+		script = m.Up
+	}
+	if _, err := tx.ExecContext(ctx, script); err != nil {
+		return err
+	}
+
+	if up {
+		_, err = tx.ExecContext(ctx,
+			`INSERT INTO schema_migrations (version, name, applied_at) VALUES ($1, $2, $3)`,
+			m.Version, m.Name, time.Now().UTC())
+	} else {
+		_, err = tx.ExecContext(ctx, `DELETE FROM schema_migrations WHERE version = $1`, m.Version)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	log.Info("migration applied", "duration", time.Since(start))
+	return nil
+}
+
+// runMigrate is the "migrate" command: "migrate up" or "migrate down N"
+// moves the schema to the latest version or to the version N.
+func runMigrate(cfg *config, args []string) int {
+	migrations, err := embeddedMigrations()
+	if err != nil {
+		slog.Error("can't load migrations", "error", err)
+		return 1
+	}
+
+	target := len(migrations)
+	switch {
+	case len(args) == 1 && args[0] == "up":
+	case len(args) == 2 && args[0] == "down":
+		target, err = strconv.Atoi(args[1])
+		if err != nil {
+			slog.Error("invalid schema version", "version", args[1])
+			return 1
+		}
+	default:
+		slog.Error(`usage: migrate up | migrate down VERSION`)
+		return 2
+	}
+
+	db, err := openDB(cfg.DSN())
+	if err != nil {
+		slog.Error("can't open database", "error", err)
+		return 1
+	}
+	defer db.Close()
+
+	if err := waitDB(db, cfg.DBUpTimeout); err != nil {
+		slog.Error("database up timeout expired", "lastErr", err)
+		return 1
+	}
+
+	if err := migrate(context.Background(), db, migrations, target); err != nil {
+		slog.Error("can't migrate", "error", err)
+		return 1
+	}
+	return 0
+}
+
+// migrateUp applies all the embedded migrations.
+func migrateUp(ctx context.Context, db *sql.DB) error {
+	migrations, err := embeddedMigrations()
+	if err != nil {
+		return err
+	}
+	return migrate(ctx, db, migrations, len(migrations))
+}
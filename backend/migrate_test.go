@@ -0,0 +1,67 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	file := func(s string) *fstest.MapFile { return &fstest.MapFile{Data: []byte(s)} }
+
+	migrations, err := loadMigrations(fstest.MapFS{
+		"0002_second.up.sql":   file("up2"),
+		"0002_second.down.sql": file("down2"),
+		"0001_init.up.sql":     file("up1"),
+		"0001_init.down.sql":   file("down1"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []migration{
+		{Version: 1, Name: "init", Up: "up1", Down: "down1"},
+		{Version: 2, Name: "second", Up: "up2", Down: "down2"},
+	}
+	if len(migrations) != len(want) {
+		t.Fatalf("got %d migrations, want %d", len(migrations), len(want))
+	}
+	for i := range want {
+		if migrations[i] != want[i] {
+			t.Errorf("migration %d = %+v, want %+v", i, migrations[i], want[i])
+		}
+	}
+
+	tests := map[string]fstest.MapFS{
+		"gap": {
+			"0001_init.up.sql": file("up"), "0001_init.down.sql": file("down"),
+			"0003_third.up.sql": file("up"), "0003_third.down.sql": file("down"),
+		},
+		"no down":        {"0001_init.up.sql": file("up")},
+		"different name": {"0001_init.up.sql": file("up"), "0001_other.down.sql": file("down")},
+		"bad file name":  {"init.sql": file("up")},
+		"zero version":   {"0000_init.up.sql": file("up"), "0000_init.down.sql": file("down")},
+	}
+	for name, fsys := range tests {
+		if _, err := loadMigrations(fsys); err == nil {
+			t.Errorf("%s: error = nil", name)
+		}
+	}
+
+	// встроенные миграции должны загружаться
+	migrations, err = embeddedMigrations()
+	if err != nil || len(migrations) == 0 {
+		t.Fatalf("embeddedMigrations() = %d migrations, %v", len(migrations), err)
+	}
+
+	// версия 1 - прежний db/init.sql
+	if !slices.IsSorted(baselineColumns) {
+		t.Errorf("baselineColumns are not sorted")
+	}
+	for _, col := range baselineColumns {
+		table, name, _ := strings.Cut(col, ".")
+		if !strings.Contains(migrations[0].Up, "CREATE TABLE "+table+" (") || !strings.Contains(migrations[0].Up, "    "+name+" ") {
+			t.Errorf("column %s is not created by migration 1", col)
+		}
+	}
+}
@@ -0,0 +1,2 @@
+DROP TABLE ping_result;
+DROP TABLE host;
@@ -8,6 +8,6 @@ CREATE TABLE ping_result (
     host_id INT NOT NULL REFERENCES host,
     ip INET NOT NULL,
     ping_time TIMESTAMP NOT NULL,
-    ping_rtt int NOT NULL, 
+    ping_rtt int NOT NULL,
     success BOOLEAN NOT NULL
 );
@@ -0,0 +1,18 @@
+DROP TABLE ip_change;
+
+-- the version 1 knows successful and failed icmp pings with an address only
+DELETE FROM ping_result WHERE ip IS NULL OR probe <> 'icmp';
+
+ALTER TABLE ping_result
+    ALTER COLUMN ip SET NOT NULL,
+    ALTER COLUMN ping_rtt TYPE INT USING LEAST(ping_rtt, 2147483647),
+    DROP COLUMN probe,
+    DROP COLUMN http_status,
+    DROP COLUMN tls_handshake,
+    DROP COLUMN reason,
+    DROP COLUMN packets_sent,
+    DROP COLUMN packets_recv,
+    DROP COLUMN packet_loss,
+    DROP COLUMN rtt_min,
+    DROP COLUMN rtt_max,
+    DROP COLUMN rtt_stddev;
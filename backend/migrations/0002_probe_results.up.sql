@@ -0,0 +1,24 @@
+-- failed pings, probe types, dns probes with the address changes and
+-- aggregated ICMP probes
+ALTER TABLE ping_result
+    ALTER COLUMN ip DROP NOT NULL, -- NULL if the host name could not be resolved
+    ALTER COLUMN ping_rtt TYPE BIGINT, -- ns, average for aggregated ICMP probe
+    ADD COLUMN probe VARCHAR(8) NOT NULL DEFAULT 'icmp', -- icmp, tcp, http, https, dns
+    ADD COLUMN http_status INT, -- http(s) only
+    ADD COLUMN tls_handshake BIGINT, -- ns, https only
+    ADD COLUMN reason TEXT, -- why the ping failed
+    -- aggregated ICMP probe only
+    ADD COLUMN packets_sent INT,
+    ADD COLUMN packets_recv INT,
+    ADD COLUMN packet_loss REAL, -- %
+    ADD COLUMN rtt_min BIGINT, -- ns
+    ADD COLUMN rtt_max BIGINT, -- ns
+    ADD COLUMN rtt_stddev BIGINT; -- ns
+
+CREATE TABLE ip_change (
+    id BIGSERIAL PRIMARY KEY,
+    host_id INT NOT NULL REFERENCES host,
+    old_ip INET, -- NULL when the host is resolved for the first time
+    new_ip INET NOT NULL,
+    change_time TIMESTAMP NOT NULL
+);
@@ -0,0 +1,6 @@
+ALTER TABLE host
+    DROP COLUMN ping_interval,
+    DROP COLUMN ping_timeout,
+    DROP COLUMN ping_count,
+    DROP COLUMN aggregate,
+    DROP COLUMN enabled;
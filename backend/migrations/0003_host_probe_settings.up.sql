@@ -0,0 +1,6 @@
+ALTER TABLE host
+    ADD COLUMN ping_interval BIGINT NOT NULL DEFAULT 0, -- ns, 0 - pinger default
+    ADD COLUMN ping_timeout BIGINT NOT NULL DEFAULT 0, -- ns, 0 - pinger default
+    ADD COLUMN ping_count INT NOT NULL DEFAULT 1, -- ICMP packets per probe
+    ADD COLUMN aggregate BOOLEAN NOT NULL DEFAULT FALSE, -- report ICMP probe statistics instead of every reply
+    ADD COLUMN enabled BOOLEAN NOT NULL DEFAULT TRUE;
@@ -0,0 +1,9 @@
+DROP INDEX ping_result_host_time_idx;
+
+ALTER TABLE ip_change
+    DROP CONSTRAINT ip_change_host_id_fkey,
+    ADD CONSTRAINT ip_change_host_id_fkey FOREIGN KEY (host_id) REFERENCES host;
+
+ALTER TABLE ping_result
+    DROP CONSTRAINT ping_result_host_id_fkey,
+    ADD CONSTRAINT ping_result_host_id_fkey FOREIGN KEY (host_id) REFERENCES host;
@@ -0,0 +1,10 @@
+-- the results of a deleted host are deleted with it
+ALTER TABLE ping_result
+    DROP CONSTRAINT ping_result_host_id_fkey,
+    ADD CONSTRAINT ping_result_host_id_fkey FOREIGN KEY (host_id) REFERENCES host ON DELETE CASCADE;
+
+ALTER TABLE ip_change
+    DROP CONSTRAINT ip_change_host_id_fkey,
+    ADD CONSTRAINT ip_change_host_id_fkey FOREIGN KEY (host_id) REFERENCES host ON DELETE CASCADE;
+
+CREATE INDEX ping_result_host_time_idx ON ping_result (host_id, ping_time, id);
@@ -0,0 +1,4 @@
+DROP TABLE rollup_watermark;
+DROP TABLE ping_result_1h;
+DROP TABLE ping_result_1m;
+DROP INDEX ping_result_time_idx;
@@ -0,0 +1,42 @@
+CREATE INDEX ping_result_time_idx ON ping_result (ping_time);
+
+-- ping results aggregated by minute and by hour, dns probes are not counted
+CREATE TABLE ping_result_1m (
+    host_id INT NOT NULL REFERENCES host ON DELETE CASCADE,
+    bucket TIMESTAMP NOT NULL,
+    probes INT NOT NULL,
+    successes INT NOT NULL,
+    rtt_min BIGINT, -- ns, successful probes only, NULL if there are none
+    rtt_avg BIGINT, -- ns
+    rtt_max BIGINT, -- ns
+    packets_sent INT NOT NULL, -- icmp only
+    packets_recv INT NOT NULL,
+    PRIMARY KEY (host_id, bucket)
+);
+
+CREATE INDEX ping_result_1m_bucket_idx ON ping_result_1m (bucket);
+
+CREATE TABLE ping_result_1h (
+    host_id INT NOT NULL REFERENCES host ON DELETE CASCADE,
+    bucket TIMESTAMP NOT NULL,
+    probes INT NOT NULL,
+    successes INT NOT NULL,
+    rtt_min BIGINT,
+    rtt_avg BIGINT,
+    rtt_max BIGINT,
+    packets_sent INT NOT NULL,
+    packets_recv INT NOT NULL,
+    PRIMARY KEY (host_id, bucket)
+);
+
+CREATE INDEX ping_result_1h_bucket_idx ON ping_result_1h (bucket);
+
+-- the results before the watermark are aggregated to the rollup table
+CREATE TABLE rollup_watermark (
+    name VARCHAR(32) PRIMARY KEY, -- the rollup table
+    watermark TIMESTAMP NOT NULL
+);
+
+INSERT INTO rollup_watermark (name, watermark) VALUES
+    ('ping_result_1m', 'epoch'),
+    ('ping_result_1h', 'epoch');
@@ -0,0 +1,39 @@
+-- the partitioned ping_result is replaced by a plain table, the id sequence is kept
+ALTER TABLE ping_result RENAME TO ping_result_old;
+ALTER TABLE ping_result_old RENAME CONSTRAINT ping_result_pkey TO ping_result_old_pkey;
+ALTER TABLE ping_result_old RENAME CONSTRAINT ping_result_host_id_fkey TO ping_result_old_host_id_fkey;
+ALTER INDEX ping_result_host_time_idx RENAME TO ping_result_old_host_time_idx;
+ALTER INDEX ping_result_time_idx RENAME TO ping_result_old_time_idx;
+ALTER SEQUENCE ping_result_id_seq OWNED BY NONE;
+
+CREATE TABLE ping_result (
+    id BIGINT NOT NULL DEFAULT nextval('ping_result_id_seq') PRIMARY KEY,
+    host_id INT NOT NULL REFERENCES host ON DELETE CASCADE,
+    probe VARCHAR(8) NOT NULL DEFAULT 'icmp',
+    ip INET,
+    ping_time TIMESTAMP NOT NULL,
+    ping_rtt BIGINT NOT NULL,
+    http_status INT,
+    tls_handshake BIGINT,
+    success BOOLEAN NOT NULL,
+    reason TEXT,
+    packets_sent INT,
+    packets_recv INT,
+    packet_loss REAL,
+    rtt_min BIGINT,
+    rtt_max BIGINT,
+    rtt_stddev BIGINT
+);
+
+ALTER SEQUENCE ping_result_id_seq OWNED BY ping_result.id;
+
+INSERT INTO ping_result (id, host_id, probe, ip, ping_time, ping_rtt, http_status, tls_handshake, success,
+    reason, packets_sent, packets_recv, packet_loss, rtt_min, rtt_max, rtt_stddev)
+SELECT id, host_id, probe, ip, ping_time, ping_rtt, http_status, tls_handshake, success,
+    reason, packets_sent, packets_recv, packet_loss, rtt_min, rtt_max, rtt_stddev
+FROM ping_result_old;
+
+DROP TABLE ping_result_old; -- with the partitions
+
+CREATE INDEX ping_result_host_time_idx ON ping_result (host_id, ping_time, id);
+CREATE INDEX ping_result_time_idx ON ping_result (ping_time);
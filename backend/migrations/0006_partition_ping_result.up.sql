@@ -0,0 +1,59 @@
+-- ping_result is replaced by the table partitioned by UTC day of ping_time,
+-- the day partitions ping_result_pYYYYMMDD are created and dropped by the
+-- backend. The id sequence is kept.
+ALTER TABLE ping_result RENAME TO ping_result_old;
+ALTER TABLE ping_result_old RENAME CONSTRAINT ping_result_pkey TO ping_result_old_pkey;
+ALTER TABLE ping_result_old RENAME CONSTRAINT ping_result_host_id_fkey TO ping_result_old_host_id_fkey;
+ALTER INDEX ping_result_host_time_idx RENAME TO ping_result_old_host_time_idx;
+ALTER INDEX ping_result_time_idx RENAME TO ping_result_old_time_idx;
+ALTER SEQUENCE ping_result_id_seq OWNED BY NONE;
+
+CREATE TABLE ping_result (
+    id BIGINT NOT NULL DEFAULT nextval('ping_result_id_seq'),
+    host_id INT NOT NULL REFERENCES host ON DELETE CASCADE,
+    probe VARCHAR(8) NOT NULL DEFAULT 'icmp', -- icmp, tcp, http, https, dns
+    ip INET, -- NULL if the host name could not be resolved
+    ping_time TIMESTAMP NOT NULL,
+    ping_rtt BIGINT NOT NULL, -- ns, average for aggregated ICMP probe
+    http_status INT, -- http(s) only
+    tls_handshake BIGINT, -- ns, https only
+    success BOOLEAN NOT NULL,
+    reason TEXT, -- why the ping failed
+    -- aggregated ICMP probe only
+    packets_sent INT,
+    packets_recv INT,
+    packet_loss REAL, -- %
+    rtt_min BIGINT, -- ns
+    rtt_max BIGINT, -- ns
+    rtt_stddev BIGINT, -- ns
+    PRIMARY KEY (id, ping_time)
+) PARTITION BY RANGE (ping_time);
+
+ALTER SEQUENCE ping_result_id_seq OWNED BY ping_result.id;
+
+-- the rows out of the day partitions, e.g. delivered too late
+CREATE TABLE ping_result_default PARTITION OF ping_result DEFAULT;
+
+-- the partitions of the current and the next days can't be created by the
+-- backend over the rows in the default partition, so they are created here
+DO $$
+DECLARE
+    day DATE;
+BEGIN
+    FOR day IN SELECT generate_series(0, 3) + (now() AT TIME ZONE 'UTC')::DATE LOOP
+        EXECUTE format('CREATE TABLE %I PARTITION OF ping_result FOR VALUES FROM (%L) TO (%L)',
+            'ping_result_p' || to_char(day, 'YYYYMMDD'), day, day + 1);
+    END LOOP;
+END
+$$;
+
+INSERT INTO ping_result (id, host_id, probe, ip, ping_time, ping_rtt, http_status, tls_handshake, success,
+    reason, packets_sent, packets_recv, packet_loss, rtt_min, rtt_max, rtt_stddev)
+SELECT id, host_id, probe, ip, ping_time, ping_rtt, http_status, tls_handshake, success,
+    reason, packets_sent, packets_recv, packet_loss, rtt_min, rtt_max, rtt_stddev
+FROM ping_result_old;
+
+DROP TABLE ping_result_old;
+
+CREATE INDEX ping_result_host_time_idx ON ping_result (host_id, ping_time, id);
+CREATE INDEX ping_result_time_idx ON ping_result (ping_time);
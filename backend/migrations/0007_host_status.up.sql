@@ -0,0 +1,71 @@
+-- the latest state of the host, upserted with the ping results, dns probes are not counted
+CREATE TABLE host_status (
+    host_id INT PRIMARY KEY REFERENCES host ON DELETE CASCADE,
+    -- the last successful result, NULL if there is none
+    success_time TIMESTAMP,
+    probe VARCHAR(8),
+    ip INET,
+    ping_rtt BIGINT, -- ns
+    http_status INT,
+    tls_handshake BIGINT, -- ns
+    packets_sent INT,
+    packets_recv INT,
+    packet_loss REAL, -- %
+    rtt_min BIGINT, -- ns
+    rtt_max BIGINT, -- ns
+    rtt_stddev BIGINT, -- ns
+    -- the last probe
+    attempt_time TIMESTAMP NOT NULL,
+    attempt_success BOOLEAN NOT NULL,
+    -- the last failed probe, NULL if there is none
+    failure_time TIMESTAMP,
+    failure_reason TEXT,
+    consecutive_failures INT NOT NULL DEFAULT 0 -- failed probes since the last successful one
+);
+
+-- the state of the results kept so far
+INSERT INTO host_status (host_id, success_time, probe, ip, ping_rtt, http_status, tls_handshake,
+    packets_sent, packets_recv, packet_loss, rtt_min, rtt_max, rtt_stddev,
+    attempt_time, attempt_success, failure_time, failure_reason, consecutive_failures)
+SELECT
+    a.host_id,
+    s.ping_time,
+    s.probe,
+    s.ip,
+    s.ping_rtt,
+    s.http_status,
+    s.tls_handshake,
+    s.packets_sent,
+    s.packets_recv,
+    s.packet_loss,
+    s.rtt_min,
+    s.rtt_max,
+    s.rtt_stddev,
+    a.ping_time,
+    a.success,
+    f.ping_time,
+    f.reason,
+    (
+        SELECT count(*)
+        FROM ping_result r
+        WHERE r.host_id = a.host_id AND r.probe <> 'dns' AND NOT r.success
+            AND r.ping_time > COALESCE(s.ping_time, '-infinity')
+    )
+FROM (
+    SELECT DISTINCT ON (host_id) host_id, ping_time, success
+    FROM ping_result
+    WHERE probe <> 'dns'
+    ORDER BY host_id, ping_time DESC, id DESC
+) AS a
+LEFT JOIN (
+    SELECT DISTINCT ON (host_id) *
+    FROM ping_result
+    WHERE probe <> 'dns' AND success
+    ORDER BY host_id, ping_time DESC, id DESC
+) AS s USING (host_id)
+LEFT JOIN (
+    SELECT DISTINCT ON (host_id) host_id, ping_time, reason
+    FROM ping_result
+    WHERE probe <> 'dns' AND NOT success
+    ORDER BY host_id, ping_time DESC, id DESC
+) AS f USING (host_id);
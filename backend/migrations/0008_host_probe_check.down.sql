@@ -0,0 +1 @@
+ALTER TABLE host DROP CONSTRAINT host_probe_settings_check;
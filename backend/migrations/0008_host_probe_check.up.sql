@@ -0,0 +1,5 @@
+-- negative settings are taken as the pinger defaults
+UPDATE host SET ping_interval = 0 WHERE ping_interval < 0;
+UPDATE host SET ping_timeout = 0 WHERE ping_timeout < 0;
+
+ALTER TABLE host ADD CONSTRAINT host_probe_settings_check CHECK (ping_interval >= 0 AND ping_timeout >= 0);
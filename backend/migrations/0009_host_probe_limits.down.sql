@@ -0,0 +1,3 @@
+ALTER TABLE host
+    DROP CONSTRAINT host_ping_interval_check,
+    DROP CONSTRAINT host_ping_timeout_check;
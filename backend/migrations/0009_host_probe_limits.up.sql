@@ -0,0 +1,7 @@
+-- an interval under 1s or a timeout over the interval keeps the pinger probing nonstop
+UPDATE host SET ping_interval = 1000000000 WHERE ping_interval > 0 AND ping_interval < 1000000000;
+UPDATE host SET ping_timeout = ping_interval WHERE ping_interval > 0 AND ping_timeout > ping_interval;
+
+ALTER TABLE host
+    ADD CONSTRAINT host_ping_interval_check CHECK (ping_interval = 0 OR ping_interval >= 1000000000),
+    ADD CONSTRAINT host_ping_timeout_check CHECK (ping_interval = 0 OR ping_timeout <= ping_interval);
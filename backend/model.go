@@ -1,17 +1,180 @@
 package main
 
-import "time"
+import (
+	"cmp"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+)
 
 type Host struct {
-	ID   int    `json:"host_id"`
-	Name string `json:"host_name"`
+	ID        int           `json:"host_id"`
+	Name      string        `json:"host_name"`
+	Interval  time.Duration `json:"interval"`  // probe interval, 0 - pinger default
+	Timeout   time.Duration `json:"timeout"`   // probe timeout, 0 - pinger default
+	Count     int           `json:"count"`     // ICMP packets per probe
+	Aggregate bool          `json:"aggregate"` // report ICMP probe statistics instead of every reply
+	Enabled   bool          `json:"enabled"`
 }
 
+const (
+	maxHostNameLength = 128
+	minHostInterval   = time.Second // a shorter one keeps the pinger probing nonstop
+)
+
+// HostPatch holds the host fields to be set, nil fields are left as is.
+type HostPatch struct {
+	Name      *string        `json:"host_name"`
+	Interval  *time.Duration `json:"interval"`
+	Timeout   *time.Duration `json:"timeout"`
+	Count     *int           `json:"count"`
+	Aggregate *bool          `json:"aggregate"`
+	Enabled   *bool          `json:"enabled"`
+}
+
+func (p HostPatch) Validate() error {
+	switch {
+	case p.Name != nil && (*p.Name == "" || len(*p.Name) > maxHostNameLength || strings.ContainsAny(*p.Name, " \t\n")):
+		return errors.New("invalid host_name")
+	case p.Interval != nil && (*p.Interval < 0 || *p.Interval > 0 && *p.Interval < minHostInterval):
+		return errors.New("invalid interval, must be 0 or at least " + minHostInterval.String())
+	case p.Timeout != nil && *p.Timeout < 0:
+		return errors.New("invalid timeout")
+	case p.Interval != nil && p.Timeout != nil && *p.Interval > 0 && *p.Timeout > *p.Interval:
+		return errTimeoutOverInterval
+	case p.Count != nil && (*p.Count < 1 || *p.Count > 100):
+		return errors.New("invalid count")
+	}
+	return nil
+}
+
+func (p HostPatch) Apply(h *Host) {
+	if p.Name != nil {
+		h.Name = *p.Name
+	}
+	if p.Interval != nil {
+		h.Interval = *p.Interval
+	}
+	if p.Timeout != nil {
+		h.Timeout = *p.Timeout
+	}
+	if p.Count != nil {
+		h.Count = *p.Count
+	}
+	if p.Aggregate != nil {
+		h.Aggregate = *p.Aggregate
+	}
+	if p.Enabled != nil {
+		h.Enabled = *p.Enabled
+	}
+}
+
+// probeDNS results track the addresses of the host, they are not pings
+const probeDNS = "dns"
+
 type PingResult struct {
-	HostID   int           `json:"host_id,omitempty"`
-	HostName string        `json:"host_name"`
-	IP       string        `json:"ip"`
-	Time     time.Time     `json:"time"`
-	Rtt      time.Duration `json:"rtt"`
-	Success  bool          `json:"success"`
+	ID           int64         `json:"-"`
+	HostID       int           `json:"host_id,omitempty"`
+	HostName     string        `json:"host_name"`
+	Probe        string        `json:"probe,omitempty"`
+	IP           string        `json:"ip"`
+	Time         time.Time     `json:"time"`
+	Rtt          time.Duration `json:"rtt"`
+	StatusCode   int           `json:"status_code,omitempty"`
+	TLSHandshake time.Duration `json:"tls_handshake,omitempty"`
+	Success      bool          `json:"success"`
+	Reason       string        `json:"reason,omitempty"`
+
+	// aggregated ICMP probe only, Rtt is the average then
+	PacketsSent int           `json:"packets_sent,omitempty"`
+	PacketsRecv int           `json:"packets_recv,omitempty"`
+	Loss        float64       `json:"loss,omitempty"` // %
+	MinRtt      time.Duration `json:"min_rtt,omitempty"`
+	MaxRtt      time.Duration `json:"max_rtt,omitempty"`
+	StdDevRtt   time.Duration `json:"stddev_rtt,omitempty"`
+}
+
+// HostStatus is the latest state of the host. DNS probes are not counted.
+type HostStatus struct {
+	HostID              int
+	LastSuccess         PingResult // zero Time if there is none
+	LastAttempt         time.Time
+	LastAttemptSuccess  bool
+	LastFailure         time.Time // zero if there is none
+	LastFailureReason   string
+	ConsecutiveFailures int // failed probes since the last successful one
+}
+
+// hostStatusUpdates reduces the results to the status of each host they
+// change, sorted by host.
+func hostStatusUpdates(results []PingResult) []HostStatus {
+	probes := make([]PingResult, 0, len(results))
+	for _, res := range results {
+		if res.Probe != probeDNS {
+			probes = append(probes, res)
+		}
+	}
+	slices.SortStableFunc(probes, func(a, b PingResult) int {
+		return cmp.Or(cmp.Compare(a.HostID, b.HostID), a.Time.Compare(b.Time))
+	})
+
+	var updates []HostStatus
+	for i, res := range probes {
+		if i == 0 || probes[i-1].HostID != res.HostID {
+			updates = append(updates, HostStatus{HostID: res.HostID})
+		}
+		st := &updates[len(updates)-1]
+		st.LastAttempt = res.Time
+		st.LastAttemptSuccess = res.Success
+		if res.Success {
+			st.LastSuccess = res
+			st.ConsecutiveFailures = 0
+		} else {
+			st.LastFailure = res.Time
+			st.LastFailureReason = res.Reason
+			st.ConsecutiveFailures++
+		}
+	}
+	return updates
+}
+
+type IPChange struct {
+	HostID   int       `json:"host_id"`
+	HostName string    `json:"host_name,omitempty"`
+	OldIP    string    `json:"old_ip"` // empty when the host is resolved for the first time
+	NewIP    string    `json:"new_ip"`
+	Time     time.Time `json:"time"`
+}
+
+// HostStats are the statistics of the host probes over a time window.
+// DNS probes are not counted.
+type HostStats struct {
+	HostID       int     `json:"host_id"`
+	HostName     string  `json:"host_name"`
+	Probes       int     `json:"probes"`
+	Successes    int     `json:"successes"`
+	Availability float64 `json:"availability"` // %, successful probes
+
+	// ICMP only, every not aggregated result is a packet
+	PacketsSent int     `json:"packets_sent"`
+	PacketsRecv int     `json:"packets_recv"`
+	Loss        float64 `json:"loss"` // %
+
+	// successful probes only, the average is taken for aggregated results
+	P50Rtt time.Duration `json:"p50_rtt"`
+	P95Rtt time.Duration `json:"p95_rtt"`
+	P99Rtt time.Duration `json:"p99_rtt"`
+	MaxRtt time.Duration `json:"max_rtt"`
+}
+
+// SeriesPoint is the aggregate of the host probes over a time bucket.
+// DNS probes are not counted.
+type SeriesPoint struct {
+	Time      time.Time     `json:"time"` // the bucket start
+	Probes    int           `json:"probes"`
+	Successes int           `json:"successes"`
+	AvgRtt    time.Duration `json:"avg_rtt"` // successful probes only
+	MaxRtt    time.Duration `json:"max_rtt"`
+	Loss      float64       `json:"loss"` // %, ICMP only
 }
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// ping_result is partitioned by the UTC day of ping_time. Partitions are created
+// a few days ahead and dropped by the retention job. The rows out of
+// the partitions (e.g. delivered too late) go to the default partition.
+const (
+	defaultPartitionsAhead = 3
+	partitionInterval      = time.Hour
+
+	partitionDay        = 24 * time.Hour
+	partitionPrefix     = "ping_result_p"
+	partitionDateLayout = "20060102"
+)
+
+func partitionName(day time.Time) string {
+	return partitionPrefix + day.Format(partitionDateLayout)
+}
+
+// parsePartitionName returns the day of the partition, ok is false if the
+// table is not a day partition.
+func parsePartitionName(name string) (day time.Time, ok bool) {
+	s, ok := strings.CutPrefix(name, partitionPrefix)
+	if !ok {
+		return time.Time{}, false
+	}
+	day, err := time.Parse(partitionDateLayout, s)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return day, true
+}
+
+type partitionRepo interface {
+	CreatePartition(ctx context.Context, day time.Time) error
+}
+
+// ensurePartitions creates the partitions from the current day to ahead days
+// ahead. It must be done before the results of the day are added,
+// otherwise the partition can't be created over the rows in the default one.
+func ensurePartitions(ctx context.Context, repo partitionRepo, now time.Time, ahead int) error {
+	day := now.UTC().Truncate(partitionDay)
+	for i := 0; i <= ahead; i++ {
+		if err := repo.CreatePartition(ctx, day.AddDate(0, 0, i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runPartitions creates the partitions ahead every interval until ctx is done.
+func runPartitions(ctx context.Context, repo partitionRepo, interval time.Duration, ahead int) {
+	tk := time.NewTicker(interval)
+	defer tk.Stop()
+
+	for {
+		select {
+		case <-tk.C:
+		case <-ctx.Done():
+			return
+		}
+
+		if err := ensurePartitions(ctx, repo, time.Now(), ahead); err != nil {
+			slog.Warn("can't create partitions ahead", "error", err)
+		}
+	}
+}
@@ -1,19 +1,25 @@
 package main
 
 import (
+	"cmp"
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"log/slog"
 	"strings"
+	"time"
+
+	"github.com/lib/pq"
 )
 
 type repo struct {
-	db *sql.DB
+	db          *sql.DB
+	rollupDelay time.Duration // results older than that are late for the rollups
 }
 
-func NewRepo(db *sql.DB) repo {
-	return repo{db: db}
+func NewRepo(db *sql.DB, rollupDelay time.Duration) repo {
+	return repo{db: db, rollupDelay: rollupDelay}
 }
 
 func (re repo) getLogger(ctx context.Context, op string) *slog.Logger {
@@ -22,8 +28,9 @@ func (re repo) getLogger(ctx context.Context, op string) *slog.Logger {
 
 func (re repo) GetHosts(ctx context.Context) ([]Host, error) {
 	log := re.getLogger(ctx, "GetHosts")
+	defer observeDBQuery("GetHosts", time.Now())
 
-	const q = `SELECT host_id, host_name FROM host;`
+	const q = `SELECT ` + hostColumns + ` FROM host;`
 
 	rows, err := re.db.QueryContext(ctx, q)
 	if err != nil {
@@ -34,15 +41,12 @@ func (re repo) GetHosts(ctx context.Context) ([]Host, error) {
 
 	hosts := []Host{}
 	for rows.Next() {
-		var (
-			id   int
-			name string
-		)
-		if err := rows.Scan(&id, &name); err != nil {
+		var host Host
+		if err := scanHost(rows, &host); err != nil {
 			log.Error(fmt.Sprintf("%v", err))
 			return nil, errInternalError
 		}
-		hosts = append(hosts, Host{id, name})
+		hosts = append(hosts, host)
 	}
 
 	if err := rows.Err(); err != nil {
@@ -54,17 +58,133 @@ func (re repo) GetHosts(ctx context.Context) ([]Host, error) {
 	return hosts, nil
 }
 
-func (re repo) AddHosts(ctx context.Context, hosts []string) error {
-	log := re.getLogger(ctx, "AddHosts")
+const hostColumns = `host_id, host_name, ping_interval, ping_timeout, ping_count, aggregate, enabled`
+
+func scanHost(row interface{ Scan(dest ...any) error }, host *Host) error {
+	return row.Scan(&host.ID, &host.Name, &host.Interval, &host.Timeout, &host.Count, &host.Aggregate, &host.Enabled)
+}
+
+// isUniqueViolation reports whether the error is a unique constraint violation.
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
+
+// isTimeoutOverInterval reports whether the error is a violation of
+// host_ping_timeout_check, the host probe timeout is over its interval.
+func isTimeoutOverInterval(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23514" && pqErr.Constraint == "host_ping_timeout_check"
+}
+
+// isForeignKeyViolation reports whether the error is a foreign key violation,
+// e.g. a result of a deleted host.
+func isForeignKeyViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23503"
+}
+
+func (re repo) AddHost(ctx context.Context, host Host) (Host, error) {
+	log := re.getLogger(ctx, "AddHost")
+	defer observeDBQuery("AddHost", time.Now())
+	log.Debug("", "host", host)
+
+	const q = `INSERT INTO host (host_name, ping_interval, ping_timeout, ping_count, aggregate, enabled)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	RETURNING ` + hostColumns + `;`
+
+	row := re.db.QueryRowContext(ctx, q, host.Name, host.Interval, host.Timeout, host.Count, host.Aggregate, host.Enabled)
+	if err := scanHost(row, &host); err != nil {
+		switch {
+		case isUniqueViolation(err):
+			log.Debug(fmt.Sprintf("%v", err))
+			return Host{}, errConflict
+		case isTimeoutOverInterval(err):
+			log.Debug(fmt.Sprintf("%v", err))
+			return Host{}, errTimeoutOverInterval
+		}
+		log.Error(fmt.Sprintf("%v", err))
+		return Host{}, errInternalError
+	}
+
+	return host, nil
+}
+
+func (re repo) UpdateHost(ctx context.Context, id int, patch HostPatch) (Host, error) {
+	log := re.getLogger(ctx, "UpdateHost")
+	defer observeDBQuery("UpdateHost", time.Now())
+	log.Debug("", "id", id, "patch", patch)
+
+	const q = `UPDATE host SET
+		host_name = COALESCE($2, host_name),
+		ping_interval = COALESCE($3, ping_interval),
+		ping_timeout = COALESCE($4, ping_timeout),
+		ping_count = COALESCE($5, ping_count),
+		aggregate = COALESCE($6, aggregate),
+		enabled = COALESCE($7, enabled)
+	WHERE host_id = $1
+	RETURNING ` + hostColumns + `;`
+
+	var host Host
+	row := re.db.QueryRowContext(ctx, q, id, patch.Name, patch.Interval, patch.Timeout, patch.Count,
+		patch.Aggregate, patch.Enabled)
+	if err := scanHost(row, &host); err != nil {
+		switch {
+		case err == sql.ErrNoRows:
+			return Host{}, errNotFound
+		case isUniqueViolation(err):
+			log.Debug(fmt.Sprintf("%v", err))
+			return Host{}, errConflict
+		case isTimeoutOverInterval(err):
+			log.Debug(fmt.Sprintf("%v", err))
+			return Host{}, errTimeoutOverInterval
+		}
+		log.Error(fmt.Sprintf("%v", err))
+		return Host{}, errInternalError
+	}
+
+	return host, nil
+}
+
+// DeleteHost deletes the host with all its results.
+func (re repo) DeleteHost(ctx context.Context, id int) error {
+	log := re.getLogger(ctx, "DeleteHost")
+	defer observeDBQuery("DeleteHost", time.Now())
+	log.Debug("", "id", id)
+
+	const q = `DELETE FROM host WHERE host_id = $1;`
+
+	res, err := re.db.ExecContext(ctx, q, id)
+	if err != nil {
+		log.Error(fmt.Sprintf("%v", err))
+		return errInternalError
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return errNotFound
+	}
+
+	return nil
+}
+
+// SeedHosts adds the hosts if the host table is empty, so that the hosts
+// deleted through the API do not come back on restart.
+func (re repo) SeedHosts(ctx context.Context, hosts []string) error {
+	log := re.getLogger(ctx, "SeedHosts")
+	defer observeDBQuery("SeedHosts", time.Now())
 	log.Debug("", "hosts", hosts)
 
-	var q = `INSERT INTO host (host_name) VALUES (%s) ON CONFLICT DO NOTHING;`
+	if len(hosts) == 0 {
+		return nil
+	}
+
+	var q = `INSERT INTO host (host_name) SELECT name FROM (VALUES (%s)) AS seed (name)
+		WHERE NOT EXISTS (SELECT 1 FROM host) ON CONFLICT DO NOTHING;`
 
 	placeholders := make([]string, 0, len(hosts))
 	values := make([]any, 0, len(hosts))
 
 	for i := 0; i < len(hosts); i++ {
-		placeholders = append(placeholders, fmt.Sprintf("$%d", i+1))
+		placeholders = append(placeholders, fmt.Sprintf("$%d::TEXT", i+1))
 		values = append(values, hosts[i])
 	}
 
@@ -78,46 +198,369 @@ func (re repo) AddHosts(ctx context.Context, hosts []string) error {
 	return nil
 }
 
-func (re repo) GetLastSuccessPingResults(ctx context.Context) ([]PingResult, error) {
-	log := re.getLogger(ctx, "GetLastSuccessPingResults")
+func (re repo) GetHostStatuses(ctx context.Context) ([]HostStatus, error) {
+	log := re.getLogger(ctx, "GetHostStatuses")
+	defer observeDBQuery("GetHostStatuses", time.Now())
 
-	// TODO: Поиск последней успешной записи в таблице ping_result этот неоптималеное
-	// решение и может создавать нагрузку на базу из-за отсутствия индекса по
-	// (host_id, ping_time). Создание индекса только для этого запроса нецелесообразно,
-	// так как запрос выполняется всего один раз при старте приложения.
-	// В качестве решения выбран вариант чтения ограниченного числа строк с конца лога
-	// с последующей выборкой из них.
-	// Альтернативное решение: поддержка отдельной таблицы с последними результатами для
-	// каждого хоста.
-	// Пока оставляем как есть, так как это макет.
+	const q = `SELECT
+		host_id,
+		success_time,
+		COALESCE(probe, ''),
+		COALESCE(host(ip), ''),
+		COALESCE(ping_rtt, 0),
+		COALESCE(http_status, 0),
+		COALESCE(tls_handshake, 0),
+		COALESCE(packets_sent, 0),
+		COALESCE(packets_recv, 0),
+		COALESCE(packet_loss, 0),
+		COALESCE(rtt_min, 0),
+		COALESCE(rtt_max, 0),
+		COALESCE(rtt_stddev, 0),
+		attempt_time,
+		attempt_success,
+		failure_time,
+		COALESCE(failure_reason, ''),
+		consecutive_failures
+	FROM host_status;`
 
-	const logTailLimit = 1000 // TODO: to config
-	const q = `WITH log_tail AS (
-		SELECT *
-		FROM ping_result
-		ORDER BY id DESC
-		LIMIT $1
-	)
-	SELECT
-		h.host_id,
+	rows, err := re.db.QueryContext(ctx, q)
+	if err != nil {
+		log.Error(fmt.Sprintf("%v", err))
+		return nil, errInternalError
+	}
+	defer rows.Close()
+
+	statuses := []HostStatus{}
+	for rows.Next() {
+		var (
+			st          HostStatus
+			successTime sql.NullTime
+			failureTime sql.NullTime
+		)
+		res := &st.LastSuccess
+		if err := rows.Scan(&st.HostID, &successTime, &res.Probe, &res.IP, &res.Rtt, &res.StatusCode,
+			&res.TLSHandshake, &res.PacketsSent, &res.PacketsRecv, &res.Loss, &res.MinRtt, &res.MaxRtt,
+			&res.StdDevRtt, &st.LastAttempt, &st.LastAttemptSuccess, &failureTime, &st.LastFailureReason,
+			&st.ConsecutiveFailures); err != nil {
+			log.Error(fmt.Sprintf("%v", err))
+			return nil, errInternalError
+		}
+		if successTime.Valid {
+			res.HostID = st.HostID
+			res.Time = successTime.Time
+			res.Success = true
+		}
+		st.LastFailure = failureTime.Time
+		statuses = append(statuses, st)
+	}
+
+	if err := rows.Err(); err != nil {
+		log.Error(fmt.Sprintf("%v", err))
+		return nil, errInternalError
+	}
+
+	log.Debug("", "statuses", statuses)
+	return statuses, nil
+}
+
+// AddPingResults saves the results, the address changes found in them and the
+// host statuses in one transaction. errUnknownHost is returned if a host of
+// the results is not in the database.
+func (re repo) AddPingResults(ctx context.Context, results []PingResult, changes []IPChange) error {
+	log := re.getLogger(ctx, "AddPingResults")
+	defer observeDBQuery("AddPingResults", time.Now())
+	log.Debug("", "results", results, "changes", changes)
+
+	var q = `INSERT INTO ping_result (host_id, probe, ip, ping_time, ping_rtt, http_status, tls_handshake,
+		success, reason, packets_sent, packets_recv, packet_loss, rtt_min, rtt_max, rtt_stddev) VALUES (%s);`
+
+	const cols = 15
+	placeholders := make([]string, 0, len(results))
+	values := make([]any, 0, len(results)*cols)
+
+	// ip is unknown if the host name could not be resolved, http_status and
+	// tls_handshake are set by http(s) probes only, packets_* and rtt_* are set
+	// by aggregated ICMP probes only
+	for i, j := 0, 0; i < len(results); i, j = i+1, j+cols {
+		p := &results[i]
+		placeholders = append(placeholders, fmt.Sprintf(
+			"$%d,COALESCE(NULLIF($%d,''),'icmp'),NULLIF($%d,'')::INET,$%d,$%d,NULLIF($%d::INT,0),NULLIF($%d::BIGINT,0),$%d,NULLIF($%d,''),"+
+				"$%d,$%d,$%d,$%d,$%d,$%d",
+			j+1, j+2, j+3, j+4, j+5, j+6, j+7, j+8, j+9, j+10, j+11, j+12, j+13, j+14, j+15))
+		values = append(values, p.HostID, p.Probe, p.IP, p.Time.UTC(), p.Rtt, p.StatusCode, p.TLSHandshake,
+			p.Success, p.Reason)
+
+		if p.PacketsSent > 0 {
+			values = append(values, p.PacketsSent, p.PacketsRecv, p.Loss, p.MinRtt, p.MaxRtt, p.StdDevRtt)
+		} else {
+			values = append(values, nil, nil, nil, nil, nil, nil)
+		}
+	}
+
+	q = fmt.Sprintf(q, strings.Join(placeholders, "),("))
+
+	tx, err := re.db.BeginTx(ctx, nil)
+	if err != nil {
+		log.Error(fmt.Sprintf("%v", err))
+		return errInternalError
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, q, values...); err != nil {
+		if isForeignKeyViolation(err) {
+			log.Warn(fmt.Sprintf("%v", err))
+			return errUnknownHost
+		}
+		log.Error(fmt.Sprintf("%v", err))
+		return errInternalError
+	}
+
+	if len(changes) > 0 {
+		q, values := insertIPChangesQuery(changes)
+		if _, err := tx.ExecContext(ctx, q, values...); err != nil {
+			log.Error(fmt.Sprintf("%v", err))
+			return errInternalError
+		}
+	}
+
+	if updates := hostStatusUpdates(results); len(updates) > 0 {
+		q, values := upsertHostStatusQuery(updates)
+		if _, err := tx.ExecContext(ctx, q, values...); err != nil {
+			log.Error(fmt.Sprintf("%v", err))
+			return errInternalError
+		}
+	}
+
+	// the results delivered late, e.g. from the pinger spool after an outage,
+	// may land behind the rollup watermarks; in time results do not lock them
+	if late := lateResultsTime(results, time.Now().Add(-re.rollupDelay)); !late.IsZero() {
+		for _, level := range rollupLevels {
+			if _, err := tx.ExecContext(ctx, level.rewind, late.UTC().Truncate(level.step)); err != nil {
+				log.Error(fmt.Sprintf("%v", err))
+				return errInternalError
+			}
+		}
+		log.Info("late results, rollups are rewound", "time", late)
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Error(fmt.Sprintf("%v", err))
+		return errInternalError
+	}
+
+	return nil
+}
+
+// upsertHostStatusQuery returns the query merging the updates into host_status.
+// The updates must be sorted by host, so the concurrent upserts do not deadlock.
+// A batch that is not newer than the last attempt, e.g. replayed from the
+// pinger spool, does not change the last attempt and the failure count.
+func upsertHostStatusQuery(updates []HostStatus) (string, []any) {
+	const q = `INSERT INTO host_status AS s (host_id, success_time, probe, ip, ping_rtt, http_status,
+		tls_handshake, packets_sent, packets_recv, packet_loss, rtt_min, rtt_max, rtt_stddev,
+		attempt_time, attempt_success, failure_time, failure_reason, consecutive_failures) VALUES (%s)
+	ON CONFLICT (host_id) DO UPDATE SET
+		%s,
+		attempt_time = GREATEST(s.attempt_time, EXCLUDED.attempt_time),
+		attempt_success = CASE WHEN EXCLUDED.attempt_time > s.attempt_time
+			THEN EXCLUDED.attempt_success ELSE s.attempt_success END,
+		failure_time = GREATEST(s.failure_time, EXCLUDED.failure_time),
+		failure_reason = CASE WHEN EXCLUDED.failure_time >= COALESCE(s.failure_time, '-infinity')
+			THEN EXCLUDED.failure_reason ELSE s.failure_reason END,
+		consecutive_failures = CASE
+			WHEN EXCLUDED.attempt_time <= s.attempt_time THEN s.consecutive_failures
+			WHEN EXCLUDED.success_time IS NOT NULL THEN EXCLUDED.consecutive_failures
+			ELSE s.consecutive_failures + EXCLUDED.consecutive_failures
+		END;`
+
+	// the last successful result is replaced as a whole by a newer one
+	successCols := []string{"success_time", "probe", "ip", "ping_rtt", "http_status", "tls_handshake",
+		"packets_sent", "packets_recv", "packet_loss", "rtt_min", "rtt_max", "rtt_stddev"}
+	set := make([]string, len(successCols))
+	for i, col := range successCols {
+		set[i] = fmt.Sprintf("%[1]s = CASE WHEN EXCLUDED.success_time >= COALESCE(s.success_time, '-infinity') "+
+			"THEN EXCLUDED.%[1]s ELSE s.%[1]s END", col)
+	}
+
+	const cols = 18
+	placeholders := make([]string, 0, len(updates))
+	values := make([]any, 0, len(updates)*cols)
+
+	for i, j := 0, 0; i < len(updates); i, j = i+1, j+cols {
+		st := &updates[i]
+		placeholders = append(placeholders, fmt.Sprintf(
+			"$%d,$%d,$%d,NULLIF($%d,'')::INET,$%d,NULLIF($%d::INT,0),NULLIF($%d::BIGINT,0),$%d,$%d,$%d,$%d,$%d,$%d,"+
+				"$%d,$%d,$%d,NULLIF($%d,''),$%d",
+			j+1, j+2, j+3, j+4, j+5, j+6, j+7, j+8, j+9, j+10, j+11, j+12, j+13, j+14, j+15, j+16, j+17, j+18))
+		values = append(values, st.HostID)
+
+		// the columns of the last successful result are NULL if there is none,
+		// packets_* and rtt_* are set by aggregated ICMP probes only
+		if res := &st.LastSuccess; !res.Time.IsZero() {
+			values = append(values, res.Time.UTC(), cmp.Or(res.Probe, "icmp"), res.IP, res.Rtt, res.StatusCode,
+				res.TLSHandshake)
+			if res.PacketsSent > 0 {
+				values = append(values, res.PacketsSent, res.PacketsRecv, res.Loss, res.MinRtt, res.MaxRtt, res.StdDevRtt)
+			} else {
+				values = append(values, nil, nil, nil, nil, nil, nil)
+			}
+		} else {
+			values = append(values, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil)
+		}
+
+		var failureTime any
+		if !st.LastFailure.IsZero() {
+			failureTime = st.LastFailure.UTC()
+		}
+		values = append(values, st.LastAttempt.UTC(), st.LastAttemptSuccess, failureTime, st.LastFailureReason,
+			st.ConsecutiveFailures)
+	}
+
+	return fmt.Sprintf(q, strings.Join(placeholders, "),("), strings.Join(set, ",\n\t\t")), values
+}
+
+func (re repo) GetLastAddrs(ctx context.Context) (map[int]string, error) {
+	log := re.getLogger(ctx, "GetLastAddrs")
+	defer observeDBQuery("GetLastAddrs", time.Now())
+
+	const q = `SELECT DISTINCT ON (host_id) host_id, host(new_ip)
+	FROM ip_change
+	ORDER BY host_id, id DESC;`
+
+	rows, err := re.db.QueryContext(ctx, q)
+	if err != nil {
+		log.Error(fmt.Sprintf("%v", err))
+		return nil, errInternalError
+	}
+	defer rows.Close()
+
+	addrs := map[int]string{}
+	for rows.Next() {
+		var (
+			hostID int
+			ip     string
+		)
+		if err := rows.Scan(&hostID, &ip); err != nil {
+			log.Error(fmt.Sprintf("%v", err))
+			return nil, errInternalError
+		}
+		addrs[hostID] = ip
+	}
+
+	if err := rows.Err(); err != nil {
+		log.Error(fmt.Sprintf("%v", err))
+		return nil, errInternalError
+	}
+
+	log.Debug("", "addrs", addrs)
+	return addrs, nil
+}
+
+// insertIPChangesQuery returns the query inserting the address changes.
+func insertIPChangesQuery(changes []IPChange) (string, []any) {
+	var q = `INSERT INTO ip_change (host_id, old_ip, new_ip, change_time) VALUES (%s);`
+
+	placeholders := make([]string, 0, len(changes))
+	values := make([]any, 0, len(changes)*4)
+
+	for i, j := 0, 0; i < len(changes); i, j = i+1, j+4 {
+		p := &changes[i]
+		placeholders = append(placeholders, fmt.Sprintf("$%d,NULLIF($%d,'')::INET,$%d,$%d", j+1, j+2, j+3, j+4))
+		values = append(values, p.HostID, p.OldIP, p.NewIP, p.Time.UTC())
+	}
+
+	return fmt.Sprintf(q, strings.Join(placeholders, "),(")), values
+}
+
+// GetIPChanges returns the latest address changes of the host (of all hosts if
+// hostID is 0), newest first.
+func (re repo) GetIPChanges(ctx context.Context, hostID int, limit int) ([]IPChange, error) {
+	log := re.getLogger(ctx, "GetIPChanges")
+	defer observeDBQuery("GetIPChanges", time.Now())
+
+	const q = `SELECT
+		c.host_id,
 		h.host_name,
-		lt.ip,
-		lt.ping_time,
-		lt.ping_rtt
-	FROM (
-		SELECT DISTINCT ON (host_id)
-			host_id,
-			ip,
-			ping_time,
-			ping_rtt
-		FROM log_tail
-		WHERE success
-		ORDER BY host_id, ping_time DESC
-	) AS lt
+		COALESCE(host(c.old_ip), ''),
+		host(c.new_ip),
+		c.change_time
+	FROM ip_change c
 	JOIN host h USING (host_id)
-	ORDER BY h.host_name;`
+	WHERE $1 = 0 OR c.host_id = $1
+	ORDER BY c.id DESC
+	LIMIT $2;`
+
+	rows, err := re.db.QueryContext(ctx, q, hostID, limit)
+	if err != nil {
+		log.Error(fmt.Sprintf("%v", err))
+		return nil, errInternalError
+	}
+	defer rows.Close()
+
+	changes := []IPChange{}
+	for rows.Next() {
+		var c IPChange
+		if err := rows.Scan(&c.HostID, &c.HostName, &c.OldIP, &c.NewIP, &c.Time); err != nil {
+			log.Error(fmt.Sprintf("%v", err))
+			return nil, errInternalError
+		}
+		changes = append(changes, c)
+	}
+
+	if err := rows.Err(); err != nil {
+		log.Error(fmt.Sprintf("%v", err))
+		return nil, errInternalError
+	}
+
+	log.Debug("", "changes", changes)
+	return changes, nil
+}
+
+// GetPingResults returns the results of the host with from <= ping_time and
+// (ping_time, id) < (before, beforeID), newest first. The last result is the
+// keyset cursor of the next page.
+func (re repo) GetPingResults(ctx context.Context, hostID int, from, before time.Time, beforeID int64,
+	limit int) ([]PingResult, error) {
 
-	rows, err := re.db.QueryContext(ctx, q, logTailLimit)
+	log := re.getLogger(ctx, "GetPingResults")
+	defer observeDBQuery("GetPingResults", time.Now())
+
+	var hostName string
+	err := re.db.QueryRowContext(ctx, `SELECT host_name FROM host WHERE host_id = $1;`, hostID).Scan(&hostName)
+	if err == sql.ErrNoRows {
+		return nil, errNotFound
+	}
+	if err != nil {
+		log.Error(fmt.Sprintf("%v", err))
+		return nil, errInternalError
+	}
+
+	// uses ping_result_host_time_idx
+	const q = `SELECT
+		id,
+		probe,
+		COALESCE(host(ip), ''),
+		ping_time,
+		ping_rtt,
+		COALESCE(http_status, 0),
+		COALESCE(tls_handshake, 0),
+		success,
+		COALESCE(reason, ''),
+		COALESCE(packets_sent, 0),
+		COALESCE(packets_recv, 0),
+		COALESCE(packet_loss, 0),
+		COALESCE(rtt_min, 0),
+		COALESCE(rtt_max, 0),
+		COALESCE(rtt_stddev, 0)
+	FROM ping_result
+	WHERE host_id = $1
+		AND ping_time >= $2
+		AND ping_time <= $3 -- the row comparison does not prune partitions
+		AND (ping_time, id) < ($3, $4)
+	ORDER BY ping_time DESC, id DESC
+	LIMIT $5;`
+
+	rows, err := re.db.QueryContext(ctx, q, hostID, from.UTC(), before.UTC(), beforeID, limit)
 	if err != nil {
 		log.Error(fmt.Sprintf("%v", err))
 		return nil, errInternalError
@@ -126,8 +569,10 @@ func (re repo) GetLastSuccessPingResults(ctx context.Context) ([]PingResult, err
 
 	results := []PingResult{}
 	for rows.Next() {
-		res := PingResult{Success: true}
-		if err := rows.Scan(&res.HostID, &res.HostName, &res.IP, &res.Time, &res.Rtt); err != nil {
+		res := PingResult{HostID: hostID, HostName: hostName}
+		if err := rows.Scan(&res.ID, &res.Probe, &res.IP, &res.Time, &res.Rtt, &res.StatusCode,
+			&res.TLSHandshake, &res.Success, &res.Reason, &res.PacketsSent, &res.PacketsRecv, &res.Loss,
+			&res.MinRtt, &res.MaxRtt, &res.StdDevRtt); err != nil {
 			log.Error(fmt.Sprintf("%v", err))
 			return nil, errInternalError
 		}
@@ -139,29 +584,341 @@ func (re repo) GetLastSuccessPingResults(ctx context.Context) ([]PingResult, err
 		return nil, errInternalError
 	}
 
-	log.Debug("", "results", results)
 	return results, nil
 }
 
-func (re repo) AddPingResults(ctx context.Context, results []PingResult) error {
-	log := re.getLogger(ctx, "AddPingResults")
-	log.Debug("", "results", results)
+// GetStats returns the statistics of the host (of all hosts if hostID is 0)
+// probes since the given time.
+func (re repo) GetStats(ctx context.Context, hostID int, since time.Time) ([]HostStats, error) {
+	log := re.getLogger(ctx, "GetStats")
+	defer observeDBQuery("GetStats", time.Now())
 
-	var q = `INSERT INTO ping_result (host_id, ip, ping_time, ping_rtt, success) VALUES (%s);`
+	// uses ping_result_host_time_idx
+	const q = `SELECT
+		h.host_id,
+		h.host_name,
+		count(r.id),
+		count(r.id) FILTER (WHERE r.success),
+		COALESCE(sum(COALESCE(r.packets_sent, 1)) FILTER (WHERE r.probe = 'icmp'), 0),
+		COALESCE(sum(COALESCE(r.packets_recv, r.success::INT)) FILTER (WHERE r.probe = 'icmp'), 0),
+		percentile_cont(ARRAY[0.5, 0.95, 0.99]) WITHIN GROUP (ORDER BY r.ping_rtt) FILTER (WHERE r.success),
+		COALESCE(max(COALESCE(r.rtt_max, r.ping_rtt)) FILTER (WHERE r.success), 0)
+	FROM host h
+	LEFT JOIN ping_result r ON r.host_id = h.host_id AND r.ping_time >= $2 AND r.probe <> 'dns'
+	WHERE $1 = 0 OR h.host_id = $1
+	GROUP BY h.host_id
+	ORDER BY h.host_name;`
 
-	placeholders := make([]string, 0, len(results))
-	values := make([]any, 0, len(results))
+	rows, err := re.db.QueryContext(ctx, q, hostID, since.UTC())
+	if err != nil {
+		log.Error(fmt.Sprintf("%v", err))
+		return nil, errInternalError
+	}
+	defer rows.Close()
 
-	for i, j := 0, 0; i < len(results); i, j = i+1, j+5 {
-		p := &results[i]
-		placeholders = append(placeholders, fmt.Sprintf("$%d,$%d,$%d,$%d,$%d", j+1, j+2, j+3, j+4, j+5))
-		values = append(values, p.HostID, p.IP, p.Time, p.Rtt, p.Success)
+	stats := []HostStats{}
+	for rows.Next() {
+		var st HostStats
+		var percentiles pq.Float64Array
+		if err := rows.Scan(&st.HostID, &st.HostName, &st.Probes, &st.Successes, &st.PacketsSent,
+			&st.PacketsRecv, &percentiles, &st.MaxRtt); err != nil {
+			log.Error(fmt.Sprintf("%v", err))
+			return nil, errInternalError
+		}
+		if st.Probes > 0 {
+			st.Availability = 100 * float64(st.Successes) / float64(st.Probes)
+		}
+		if st.PacketsSent > 0 {
+			st.Loss = 100 * float64(st.PacketsSent-st.PacketsRecv) / float64(st.PacketsSent)
+		}
+		if len(percentiles) == 3 {
+			st.P50Rtt = time.Duration(percentiles[0])
+			st.P95Rtt = time.Duration(percentiles[1])
+			st.P99Rtt = time.Duration(percentiles[2])
+		}
+		stats = append(stats, st)
 	}
 
-	q = fmt.Sprintf(q, strings.Join(placeholders, "),("))
+	if err := rows.Err(); err != nil {
+		log.Error(fmt.Sprintf("%v", err))
+		return nil, errInternalError
+	}
 
-	if _, err := re.db.ExecContext(ctx, q, values...); err != nil {
+	if hostID != 0 && len(stats) == 0 {
+		return nil, errNotFound
+	}
+
+	return stats, nil
+}
+
+// GetSeries returns the host probes in the [from, to) range aggregated by step.
+// Buckets are aligned to the Unix epoch, empty buckets are omitted. The coarsest
+// rollup the step is a multiple of is used up to its watermark, raw results after it.
+func (re repo) GetSeries(ctx context.Context, hostID int, from, to time.Time, step time.Duration) ([]SeriesPoint, error) {
+	log := re.getLogger(ctx, "GetSeries")
+	defer observeDBQuery("GetSeries", time.Now())
+
+	var exists bool
+	err := re.db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM host WHERE host_id = $1);`, hostID).Scan(&exists)
+	if err != nil {
+		log.Error(fmt.Sprintf("%v", err))
+		return nil, errInternalError
+	}
+	if !exists {
+		return nil, errNotFound
+	}
+
+	// every piece is a raw result or a rollup bucket, rtt_sum is the sum of successful probes RTT;
+	// the column names are taken from the first query of the union
+	const rawPieces = `SELECT
+			ping_time AS t,
+			1 AS probes,
+			success::INT AS successes,
+			CASE WHEN success THEN ping_rtt END AS rtt_sum,
+			CASE WHEN success THEN COALESCE(rtt_max, ping_rtt) END AS rtt_max,
+			CASE WHEN probe = 'icmp' THEN COALESCE(packets_sent, 1) ELSE 0 END AS packets_sent,
+			CASE WHEN probe = 'icmp' THEN COALESCE(packets_recv, success::INT) ELSE 0 END AS packets_recv
+		FROM ping_result
+		WHERE host_id = $1 AND ping_time >= GREATEST($2::TIMESTAMP, $5::TIMESTAMP) AND ping_time < $3
+			AND probe <> 'dns'`
+
+	const rollupPieces = `SELECT
+			bucket,
+			probes,
+			successes,
+			rtt_avg::NUMERIC * successes,
+			rtt_max,
+			packets_sent,
+			packets_recv
+		FROM %s
+		WHERE host_id = $1 AND bucket >= $2 AND bucket < LEAST($3::TIMESTAMP, $5::TIMESTAMP)`
+
+	const q = `WITH pieces AS (
+		%s
+	)
+	SELECT
+		date_bin(make_interval(secs => $4::BIGINT / 1e9), t, 'epoch') AS bucket,
+		sum(probes),
+		sum(successes),
+		COALESCE(sum(rtt_sum) / NULLIF(sum(successes), 0), 0)::BIGINT,
+		COALESCE(max(rtt_max), 0),
+		sum(packets_sent),
+		sum(packets_recv)
+	FROM pieces
+	GROUP BY bucket
+	ORDER BY bucket;`
+
+	// uses ping_result_host_time_idx and the rollup primary key
+	pieces := rawPieces
+	watermark := from
+	if level := seriesLevel(step); level != nil {
+		if watermark, err = re.GetRollupWatermark(ctx, level.table); err != nil {
+			return nil, err
+		}
+		from = from.UTC().Truncate(level.step)
+		pieces += "\n\t\tUNION ALL\n\t\t" + fmt.Sprintf(rollupPieces, level.table)
+	}
+
+	rows, err := re.db.QueryContext(ctx, fmt.Sprintf(q, pieces), hostID, from.UTC(), to.UTC(), int64(step),
+		watermark.UTC())
+	if err != nil {
+		log.Error(fmt.Sprintf("%v", err))
+		return nil, errInternalError
+	}
+	defer rows.Close()
+
+	points := []SeriesPoint{}
+	for rows.Next() {
+		var pt SeriesPoint
+		var sent, recv int
+		if err := rows.Scan(&pt.Time, &pt.Probes, &pt.Successes, &pt.AvgRtt, &pt.MaxRtt, &sent, &recv); err != nil {
+			log.Error(fmt.Sprintf("%v", err))
+			return nil, errInternalError
+		}
+		if sent > 0 {
+			pt.Loss = 100 * float64(sent-recv) / float64(sent)
+		}
+		points = append(points, pt)
+	}
+
+	if err := rows.Err(); err != nil {
 		log.Error(fmt.Sprintf("%v", err))
+		return nil, errInternalError
+	}
+
+	return points, nil
+}
+
+// Rollup aggregates the next range of the level source up to until and moves
+// the level watermark to the end of the range. Returns the watermark, it is not
+// changed when there is nothing to aggregate. Aggregation is idempotent, so a
+// range may be aggregated again.
+func (re repo) Rollup(ctx context.Context, level rollupLevel, until time.Time) (time.Time, error) {
+	log := re.getLogger(ctx, "Rollup").With("table", level.table)
+	defer observeDBQuery("Rollup", time.Now())
+
+	tx, err := re.db.BeginTx(ctx, nil)
+	if err != nil {
+		log.Error(fmt.Sprintf("%v", err))
+		return time.Time{}, errInternalError
+	}
+	defer tx.Rollback()
+
+	// the row lock serializes the workers of backend replicas
+	var watermark time.Time
+	err = tx.QueryRowContext(ctx, `SELECT watermark FROM rollup_watermark WHERE name = $1 FOR UPDATE;`,
+		level.table).Scan(&watermark)
+	if err != nil {
+		log.Error(fmt.Sprintf("%v", err))
+		return time.Time{}, errInternalError
+	}
+
+	// skip the gap without data, e.g. after the first start
+	var first sql.NullTime
+	if err := tx.QueryRowContext(ctx, level.first, watermark).Scan(&first); err != nil {
+		log.Error(fmt.Sprintf("%v", err))
+		return time.Time{}, errInternalError
+	}
+	if !first.Valid {
+		return watermark, nil
+	}
+
+	start := first.Time.Truncate(level.step)
+	if start.Before(watermark) {
+		start = watermark
+	}
+	end := until.UTC().Truncate(level.step)
+	if end.Sub(start) > level.maxRange {
+		end = start.Add(level.maxRange)
+	}
+	if !end.After(start) {
+		return watermark, nil
+	}
+
+	if _, err := tx.ExecContext(ctx, level.query, start, end); err != nil {
+		log.Error(fmt.Sprintf("%v", err))
+		return time.Time{}, errInternalError
+	}
+
+	if _, err := tx.ExecContext(ctx, `UPDATE rollup_watermark SET watermark = $2 WHERE name = $1;`,
+		level.table, end); err != nil {
+		log.Error(fmt.Sprintf("%v", err))
+		return time.Time{}, errInternalError
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Error(fmt.Sprintf("%v", err))
+		return time.Time{}, errInternalError
+	}
+
+	return end, nil
+}
+
+// GetRollupWatermark returns the time the rollup table is filled up to.
+func (re repo) GetRollupWatermark(ctx context.Context, name string) (time.Time, error) {
+	log := re.getLogger(ctx, "GetRollupWatermark")
+	defer observeDBQuery("GetRollupWatermark", time.Now())
+
+	var watermark time.Time
+	err := re.db.QueryRowContext(ctx, `SELECT watermark FROM rollup_watermark WHERE name = $1;`, name).Scan(&watermark)
+	if err != nil {
+		log.Error(fmt.Sprintf("%v", err))
+		return time.Time{}, errInternalError
+	}
+
+	return watermark, nil
+}
+
+// Purge deletes up to limit rows of the target table older than before.
+// Returns the number of deleted rows.
+func (re repo) Purge(ctx context.Context, target purgeTarget, before time.Time, limit int) (int64, error) {
+	log := re.getLogger(ctx, "Purge").With("table", target.table)
+	defer observeDBQuery("Purge", time.Now())
+
+	// a small batch holds the row locks for a short time
+	q := fmt.Sprintf(`DELETE FROM %[1]s
+	WHERE ctid IN (
+		SELECT ctid
+		FROM %[1]s
+		WHERE %[2]s < $1
+		LIMIT $2
+	);`, target.table, target.column)
+
+	res, err := re.db.ExecContext(ctx, q, before.UTC(), limit)
+	if err != nil {
+		log.Error(fmt.Sprintf("%v", err))
+		return 0, errInternalError
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		log.Error(fmt.Sprintf("%v", err))
+		return 0, errInternalError
+	}
+
+	return n, nil
+}
+
+// CreatePartition creates the ping_result partition of the day if it does not exist.
+func (re repo) CreatePartition(ctx context.Context, day time.Time) error {
+	log := re.getLogger(ctx, "CreatePartition")
+	defer observeDBQuery("CreatePartition", time.Now())
+
+	q := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s PARTITION OF ping_result FOR VALUES FROM ('%s') TO ('%s');`,
+		partitionName(day), day.Format(time.DateOnly), day.AddDate(0, 0, 1).Format(time.DateOnly))
+
+	if _, err := re.db.ExecContext(ctx, q); err != nil {
+		log.Error(fmt.Sprintf("%v", err), "partition", partitionName(day))
+		return errInternalError
+	}
+
+	return nil
+}
+
+// GetPartitions returns the days of the existing ping_result partitions.
+func (re repo) GetPartitions(ctx context.Context) ([]time.Time, error) {
+	log := re.getLogger(ctx, "GetPartitions")
+	defer observeDBQuery("GetPartitions", time.Now())
+
+	const q = `SELECT c.relname
+	FROM pg_inherits i
+	JOIN pg_class c ON c.oid = i.inhrelid
+	WHERE i.inhparent = 'ping_result'::REGCLASS;`
+
+	rows, err := re.db.QueryContext(ctx, q)
+	if err != nil {
+		log.Error(fmt.Sprintf("%v", err))
+		return nil, errInternalError
+	}
+	defer rows.Close()
+
+	var days []time.Time
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			log.Error(fmt.Sprintf("%v", err))
+			return nil, errInternalError
+		}
+		if day, ok := parsePartitionName(name); ok {
+			days = append(days, day)
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		log.Error(fmt.Sprintf("%v", err))
+		return nil, errInternalError
+	}
+
+	return days, nil
+}
+
+// DropPartition drops the ping_result partition of the day with all its rows.
+func (re repo) DropPartition(ctx context.Context, day time.Time) error {
+	log := re.getLogger(ctx, "DropPartition")
+	defer observeDBQuery("DropPartition", time.Now())
+
+	if _, err := re.db.ExecContext(ctx, `DROP TABLE IF EXISTS `+partitionName(day)+`;`); err != nil {
+		log.Error(fmt.Sprintf("%v", err), "partition", partitionName(day))
 		return errInternalError
 	}
 
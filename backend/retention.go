@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+const purgeBatchPause = 100 * time.Millisecond // lets the writers take the locks between batches
+
+// retentionPolicy is how long the results are kept, 0 - forever.
+type retentionPolicy struct {
+	Interval time.Duration // how often the purge job runs
+	Raw      time.Duration
+	Minute   time.Duration
+	Hour     time.Duration
+
+	BatchSize int // rows deleted per statement
+}
+
+var defaultRetention = retentionPolicy{
+	Interval: time.Hour,
+	Raw:      7 * 24 * time.Hour,
+	Minute:   90 * 24 * time.Hour,
+	Hour:     2 * 365 * 24 * time.Hour,
+
+	BatchSize: 5000,
+}
+
+// purgeTarget is a table purged by the retention job.
+type purgeTarget struct {
+	table  string
+	column string // the row time
+	rollup string // the rollup table that must aggregate the rows before they are purged
+
+	// the ping_result day partitions are dropped, the rows are deleted from the default one
+	partitioned bool
+}
+
+var (
+	purgeRaw    = purgeTarget{table: "ping_result_default", column: "ping_time", rollup: rollup1m.table, partitioned: true}
+	purgeMinute = purgeTarget{table: rollup1m.table, column: "bucket", rollup: rollup1h.table}
+	purgeHour   = purgeTarget{table: rollup1h.table, column: "bucket"}
+)
+
+type retentionRepo interface {
+	GetRollupWatermark(ctx context.Context, name string) (time.Time, error)
+	Purge(ctx context.Context, target purgeTarget, before time.Time, limit int) (int64, error)
+	GetPartitions(ctx context.Context) ([]time.Time, error)
+	DropPartition(ctx context.Context, day time.Time) error
+}
+
+// runRetention purges the expired results every policy interval until ctx is done.
+func runRetention(ctx context.Context, repo retentionRepo, policy retentionPolicy) {
+	tk := time.NewTicker(policy.Interval)
+	defer tk.Stop()
+
+	for {
+		purgeOnce(ctx, repo, policy, time.Now())
+
+		select {
+		case <-tk.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func purgeOnce(ctx context.Context, repo retentionRepo, policy retentionPolicy, now time.Time) {
+	for _, p := range []struct {
+		target    purgeTarget
+		retention time.Duration
+	}{
+		{purgeRaw, policy.Raw},
+		{purgeMinute, policy.Minute},
+		{purgeHour, policy.Hour},
+	} {
+		if p.retention <= 0 {
+			continue
+		}
+		if err := purgeTable(ctx, repo, p.target, now.Add(-p.retention), policy.BatchSize); err != nil {
+			return
+		}
+	}
+}
+
+// purgeTable deletes the rows older than before in batches of batchSize rows.
+// The rows that are not aggregated to the rollup table yet are kept.
+func purgeTable(ctx context.Context, repo retentionRepo, target purgeTarget, before time.Time, batchSize int) error {
+	log := slog.With("op", "purge", "table", target.table)
+
+	if target.rollup != "" {
+		watermark, err := repo.GetRollupWatermark(ctx, target.rollup)
+		if err != nil {
+			return err
+		}
+		if watermark.Before(before) {
+			log.Warn("rollup is behind retention, keep not aggregated rows", "watermark", watermark)
+			before = watermark
+		}
+	}
+
+	if target.partitioned {
+		if err := dropPartitions(ctx, repo, before); err != nil {
+			return err
+		}
+	}
+
+	start := time.Now()
+	var deleted int64
+	defer func() {
+		elapsed := time.Since(start)
+		retentionRowsDeleted.WithLabelValues(target.table).Add(float64(deleted))
+		retentionDuration.WithLabelValues(target.table).Set(elapsed.Seconds())
+		log.Info("purged", "before", before, "rows", deleted, "duration", elapsed)
+	}()
+
+	for {
+		n, err := repo.Purge(ctx, target, before, batchSize)
+		deleted += n
+		if err != nil {
+			return err
+		}
+		if n < int64(batchSize) {
+			return nil
+		}
+
+		select {
+		case <-time.After(purgeBatchPause):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// dropPartitions drops the ping_result partitions that end before the given time.
+func dropPartitions(ctx context.Context, repo retentionRepo, before time.Time) error {
+	days, err := repo.GetPartitions(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, day := range days {
+		if day.Add(partitionDay).After(before) {
+			continue
+		}
+		if err := repo.DropPartition(ctx, day); err != nil {
+			return err
+		}
+		retentionPartitionsDropped.Inc()
+		slog.Info("partition dropped", "op", "purge", "partition", partitionName(day))
+	}
+
+	return nil
+}
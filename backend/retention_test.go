@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+)
+
+// fakeRetentionRepo удаляет строки пачками из заданного числа строк каждой таблицы
+type fakeRetentionRepo struct {
+	watermarks map[string]time.Time
+	rows       map[string]int
+	before     map[string]time.Time // граница последнего удаления
+	partitions []time.Time
+}
+
+func (re *fakeRetentionRepo) GetRollupWatermark(ctx context.Context, name string) (time.Time, error) {
+	return re.watermarks[name], nil
+}
+
+func (re *fakeRetentionRepo) Purge(ctx context.Context, target purgeTarget, before time.Time, limit int) (int64, error) {
+	re.before[target.table] = before
+	n := min(re.rows[target.table], limit)
+	re.rows[target.table] -= n
+	return int64(n), nil
+}
+
+func (re *fakeRetentionRepo) GetPartitions(ctx context.Context) ([]time.Time, error) {
+	return slices.Clone(re.partitions), nil
+}
+
+func (re *fakeRetentionRepo) DropPartition(ctx context.Context, day time.Time) error {
+	re.partitions = slices.DeleteFunc(re.partitions, day.Equal)
+	return nil
+}
+
+func TestPurgeOnce(t *testing.T) {
+	now := time.Date(2025, 2, 10, 12, 0, 0, 0, time.UTC)
+	re := &fakeRetentionRepo{
+		watermarks: map[string]time.Time{
+			rollup1m.table: now.Add(-time.Hour),
+			rollup1h.table: now.Add(-100 * 24 * time.Hour), // часовые агрегаты отстали
+		},
+		rows: map[string]int{
+			purgeRaw.table:    2*defaultRetention.BatchSize + 1,
+			purgeMinute.table: 10,
+			purgeHour.table:   10,
+		},
+		before: map[string]time.Time{},
+	}
+	day := now.Truncate(partitionDay)
+	for i := -3; i <= defaultPartitionsAhead; i++ {
+		re.partitions = append(re.partitions, day.AddDate(0, 0, i))
+	}
+	policy := retentionPolicy{Raw: 24 * time.Hour, Minute: 90 * 24 * time.Hour, BatchSize: defaultRetention.BatchSize}
+
+	purgeOnce(context.Background(), re, policy, now)
+
+	if re.rows[purgeRaw.table] != 0 {
+		t.Errorf("raw rows left = %d, want 0", re.rows[purgeRaw.table])
+	}
+	if got, want := re.before[purgeRaw.table], now.Add(-policy.Raw); !got.Equal(want) {
+		t.Errorf("raw purged before %v, want %v", got, want)
+	}
+	// удаляются только секции, целиком старше суток
+	if want := []time.Time{day.AddDate(0, 0, -1)}; !slices.EqualFunc(re.partitions[:1], want, time.Time.Equal) ||
+		len(re.partitions) != defaultPartitionsAhead+2 {
+		t.Errorf("partitions = %v, want from %v", re.partitions, want[0])
+	}
+	// неагрегированные минутные строки не удаляются
+	if got, want := re.before[purgeMinute.table], re.watermarks[rollup1h.table]; !got.Equal(want) {
+		t.Errorf("1m purged before %v, want %v", got, want)
+	}
+	// часовые агрегаты хранятся вечно
+	if _, ok := re.before[purgeHour.table]; ok {
+		t.Errorf("1h purged, want kept forever")
+	}
+}
+
+func TestPartitionName(t *testing.T) {
+	day := time.Date(2025, 2, 9, 0, 0, 0, 0, time.UTC)
+	name := partitionName(day)
+	if name != "ping_result_p20250209" {
+		t.Errorf("partitionName() = %q", name)
+	}
+	if got, ok := parsePartitionName(name); !ok || !got.Equal(day) {
+		t.Errorf("parsePartitionName(%q) = %v, %v", name, got, ok)
+	}
+	for _, name := range []string{"ping_result_default", "ping_result_p2025", "ping_result_1m"} {
+		if _, ok := parsePartitionName(name); ok {
+			t.Errorf("parsePartitionName(%q) ok, want not a partition", name)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+const (
+	rollupInterval     = time.Minute
+	defaultRollupDelay = 5 * time.Minute // results are delivered late by the pinger spool and retries
+)
+
+// rollupLevel is a table of ping results aggregated by step. The table is filled
+// from its source up to the watermark stored in the rollup_watermark table.
+type rollupLevel struct {
+	table    string
+	step     time.Duration
+	maxRange time.Duration // per transaction
+	first    string        // returns the first source time >= $1
+	query    string        // aggregates the source in [$1, $2) to the table
+
+	// rewind moves the watermark back to $1, if the source rows before $1 are
+	// kept by retention, so the bucket of a late result is aggregated again
+	rewind string
+}
+
+var (
+	rollup1m = rollupLevel{
+		table:    "ping_result_1m",
+		step:     time.Minute,
+		maxRange: 6 * time.Hour,
+		first:    `SELECT min(ping_time) FROM ping_result WHERE ping_time >= $1;`,
+		query: `INSERT INTO ping_result_1m (host_id, bucket, probes, successes, rtt_min, rtt_avg, rtt_max,
+			packets_sent, packets_recv)
+		SELECT
+			host_id,
+			date_trunc('minute', ping_time) AS bucket,
+			count(*),
+			count(*) FILTER (WHERE success),
+			min(COALESCE(rtt_min, ping_rtt)) FILTER (WHERE success),
+			avg(ping_rtt) FILTER (WHERE success)::BIGINT,
+			max(COALESCE(rtt_max, ping_rtt)) FILTER (WHERE success),
+			COALESCE(sum(COALESCE(packets_sent, 1)) FILTER (WHERE probe = 'icmp'), 0),
+			COALESCE(sum(COALESCE(packets_recv, success::INT)) FILTER (WHERE probe = 'icmp'), 0)
+		FROM ping_result
+		WHERE ping_time >= $1 AND ping_time < $2 AND probe <> 'dns'
+		GROUP BY host_id, bucket
+		ON CONFLICT (host_id, bucket) DO UPDATE SET
+			probes = EXCLUDED.probes,
+			successes = EXCLUDED.successes,
+			rtt_min = EXCLUDED.rtt_min,
+			rtt_avg = EXCLUDED.rtt_avg,
+			rtt_max = EXCLUDED.rtt_max,
+			packets_sent = EXCLUDED.packets_sent,
+			packets_recv = EXCLUDED.packets_recv;`,
+		rewind: `UPDATE rollup_watermark SET watermark = $1 WHERE name = 'ping_result_1m' AND watermark > $1
+			AND EXISTS (SELECT 1 FROM ping_result WHERE ping_time < $1);`,
+	}
+
+	rollup1h = rollupLevel{
+		table:    "ping_result_1h",
+		step:     time.Hour,
+		maxRange: 7 * 24 * time.Hour,
+		first:    `SELECT min(bucket) FROM ping_result_1m WHERE bucket >= $1;`,
+		query: `INSERT INTO ping_result_1h (host_id, bucket, probes, successes, rtt_min, rtt_avg, rtt_max,
+			packets_sent, packets_recv)
+		SELECT
+			host_id,
+			date_trunc('hour', bucket) AS hour,
+			sum(probes),
+			sum(successes),
+			min(rtt_min),
+			(sum(rtt_avg * successes) / NULLIF(sum(successes), 0))::BIGINT,
+			max(rtt_max),
+			sum(packets_sent),
+			sum(packets_recv)
+		FROM ping_result_1m
+		WHERE bucket >= $1 AND bucket < $2
+		GROUP BY host_id, hour
+		ON CONFLICT (host_id, bucket) DO UPDATE SET
+			probes = EXCLUDED.probes,
+			successes = EXCLUDED.successes,
+			rtt_min = EXCLUDED.rtt_min,
+			rtt_avg = EXCLUDED.rtt_avg,
+			rtt_max = EXCLUDED.rtt_max,
+			packets_sent = EXCLUDED.packets_sent,
+			packets_recv = EXCLUDED.packets_recv;`,
+		rewind: `UPDATE rollup_watermark SET watermark = $1 WHERE name = 'ping_result_1h' AND watermark > $1
+			AND EXISTS (SELECT 1 FROM ping_result_1m WHERE bucket < $1);`,
+	}
+
+	// each level is filled from the previous one
+	rollupLevels = []rollupLevel{rollup1m, rollup1h}
+)
+
+// seriesLevel returns the coarsest rollup level the step is a multiple of,
+// nil if the series must be built from the raw results.
+func seriesLevel(step time.Duration) *rollupLevel {
+	for i := len(rollupLevels) - 1; i >= 0; i-- {
+		if step%rollupLevels[i].step == 0 {
+			return &rollupLevels[i]
+		}
+	}
+	return nil
+}
+
+type rollupRepo interface {
+	Rollup(ctx context.Context, level rollupLevel, until time.Time) (time.Time, error)
+}
+
+// lateResultsTime returns the time of the oldest result before the given time,
+// zero if there is none. DNS results are not aggregated and not counted.
+func lateResultsTime(results []PingResult, before time.Time) time.Time {
+	var late time.Time
+	for _, res := range results {
+		if res.Probe != probeDNS && res.Time.Before(before) && (late.IsZero() || res.Time.Before(late)) {
+			late = res.Time
+		}
+	}
+	return late
+}
+
+// runRollup aggregates the ping results into the rollup tables every interval
+// until ctx is done. Results older than delay are aggregated; the buckets of
+// the results that arrive later are aggregated again, see rollupLevel.rewind.
+func runRollup(ctx context.Context, repo rollupRepo, interval, delay time.Duration) {
+	tk := time.NewTicker(interval)
+	defer tk.Stop()
+
+	for {
+		rollupOnce(ctx, repo, time.Now().Add(-delay))
+
+		select {
+		case <-tk.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// rollupOnce brings the watermarks of all levels up to until.
+func rollupOnce(ctx context.Context, repo rollupRepo, until time.Time) {
+	for _, level := range rollupLevels {
+		var watermark time.Time
+		for {
+			wm, err := repo.Rollup(ctx, level, until)
+			if err != nil {
+				return
+			}
+			if wm.Equal(watermark) {
+				break // no data up to until
+			}
+			watermark = wm
+			slog.Debug("rollup", "table", level.table, "watermark", watermark)
+			if !watermark.Before(until.Truncate(level.step)) {
+				break // caught up
+			}
+		}
+		until = watermark
+	}
+}
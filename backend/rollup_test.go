@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// fakeRollupRepo двигает водяные знаки уровней так же, как repo.Rollup,
+// считая, что данные есть с момента first
+type fakeRollupRepo struct {
+	first      time.Time
+	watermarks map[string]time.Time
+	calls      int
+}
+
+func (re *fakeRollupRepo) Rollup(ctx context.Context, level rollupLevel, until time.Time) (time.Time, error) {
+	re.calls++
+	wm := re.watermarks[level.table]
+	start := re.first.Truncate(level.step)
+	if start.Before(wm) {
+		start = wm
+	}
+	end := until.Truncate(level.step)
+	if end.Sub(start) > level.maxRange {
+		end = start.Add(level.maxRange)
+	}
+	if !end.After(start) {
+		return wm, nil
+	}
+	re.watermarks[level.table] = end
+	return end, nil
+}
+
+func TestRollupOnce(t *testing.T) {
+	first := time.Date(2025, 2, 1, 10, 30, 15, 0, time.UTC)
+	re := &fakeRollupRepo{first: first, watermarks: map[string]time.Time{}}
+
+	// два дня данных не помещаются в один диапазон минутного уровня
+	until := first.Add(48*time.Hour + 20*time.Minute)
+	rollupOnce(context.Background(), re, until)
+
+	if got, want := re.watermarks[rollup1m.table], until.Truncate(time.Minute); !got.Equal(want) {
+		t.Errorf("1m watermark = %v, want %v", got, want)
+	}
+	// часовой уровень догоняет минутный, но не заходит за него
+	if got, want := re.watermarks[rollup1h.table], until.Truncate(time.Hour); !got.Equal(want) {
+		t.Errorf("1h watermark = %v, want %v", got, want)
+	}
+
+	// повторный запуск без новых данных ничего не меняет
+	calls := re.calls
+	rollupOnce(context.Background(), re, until)
+	if re.calls-calls != len(rollupLevels) {
+		t.Errorf("calls = %d, want one per level", re.calls-calls)
+	}
+}
+
+func TestSeriesLevel(t *testing.T) {
+	tests := []struct {
+		step time.Duration
+		want string
+	}{
+		{10 * time.Second, ""},
+		{90 * time.Second, ""},
+		{time.Minute, rollup1m.table},
+		{15 * time.Minute, rollup1m.table},
+		{time.Hour, rollup1h.table},
+		{24 * time.Hour, rollup1h.table},
+	}
+	for _, tt := range tests {
+		var got string
+		if level := seriesLevel(tt.step); level != nil {
+			got = level.table
+		}
+		if got != tt.want {
+			t.Errorf("seriesLevel(%v) = %q, want %q", tt.step, got, tt.want)
+		}
+	}
+}
+
+func TestLateResultsTime(t *testing.T) {
+	now := time.Now()
+	before := now.Add(-5 * time.Minute)
+	results := []PingResult{
+		{HostID: 1, Time: now},
+		{HostID: 1, Time: now.Add(-time.Hour), Probe: probeDNS},
+		{HostID: 2, Time: now.Add(-10 * time.Minute)},
+		{HostID: 1, Time: now.Add(-20 * time.Minute)},
+	}
+
+	// dns не агрегируется и не сдвигает водяные знаки
+	if got, want := lateResultsTime(results, before), now.Add(-20*time.Minute); !got.Equal(want) {
+		t.Errorf("lateResultsTime() = %v, want %v", got, want)
+	}
+	if got := lateResultsTime(results[:2], before); !got.IsZero() {
+		t.Errorf("lateResultsTime() = %v, want zero", got)
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	defaultStreamSubscribers = 100
+	streamHistorySize        = 1000 // events kept to resume the stream after reconnect
+	streamBufferSize         = 64   // events queued per subscriber, a slower one is disconnected
+)
+
+type streamEvent struct {
+	ID   string
+	Type string
+	Data []byte
+}
+
+// streamBroker fans out the events to the subscribers and keeps the recent
+// events to resume the stream from Last-Event-ID. Event IDs are prefixed with
+// the broker instance, so the IDs of a restarted backend are not mixed up.
+type streamBroker struct {
+	instance string
+	maxSubs  int
+
+	mu      sync.Mutex
+	closed  bool
+	subs    map[chan streamEvent]struct{}
+	seq     uint64
+	history []streamEvent // oldest first
+}
+
+func newStreamBroker(maxSubs int) *streamBroker {
+	return &streamBroker{
+		instance: strconv.FormatUint(rand.Uint64(), 36),
+		maxSubs:  maxSubs,
+		subs:     map[chan streamEvent]struct{}{},
+	}
+}
+
+// streamSubscription is the events of a subscriber, Backlog is sent first.
+// Events is closed when the subscriber is too slow or the broker is closed.
+type streamSubscription struct {
+	Backlog []streamEvent
+	Events  <-chan streamEvent
+
+	broker *streamBroker
+	ch     chan streamEvent
+}
+
+func (s *streamSubscription) Close() {
+	s.broker.unsubscribe(s.ch)
+}
+
+// Subscribe registers a subscriber. The events after lastEventID are returned
+// as the backlog; complete is false if some of them are lost, then the
+// subscriber needs the snapshot of the current state.
+func (b *streamBroker) Subscribe(lastEventID string) (sub *streamSubscription, complete bool, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed || len(b.subs) >= b.maxSubs {
+		return nil, false, errTooManySubscribers
+	}
+
+	ch := make(chan streamEvent, streamBufferSize)
+	b.subs[ch] = struct{}{}
+	sub = &streamSubscription{Events: ch, broker: b, ch: ch}
+
+	lastSeq, ok := b.parseID(lastEventID)
+	if !ok || lastSeq > b.seq {
+		return sub, false, nil
+	}
+
+	first := b.seq - uint64(len(b.history)) // seq of the event before the history
+	if lastSeq < first {
+		return sub, false, nil
+	}
+
+	sub.Backlog = append([]streamEvent(nil), b.history[lastSeq-first:]...)
+	return sub, true, nil
+}
+
+func (b *streamBroker) unsubscribe(ch chan streamEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.subs[ch]; ok {
+		delete(b.subs, ch)
+		close(ch)
+	}
+}
+
+// Publish sends the event to all subscribers. Returns the event ID.
+func (b *streamBroker) Publish(typ string, data []byte) string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.seq++
+	ev := streamEvent{ID: b.formatID(b.seq), Type: typ, Data: data}
+
+	if len(b.history) == streamHistorySize {
+		b.history = append(b.history[:0], b.history[1:]...)
+	}
+	b.history = append(b.history, ev)
+
+	for ch := range b.subs {
+		select {
+		case ch <- ev:
+		default:
+			// the subscriber will resume from its last event after reconnect
+			delete(b.subs, ch)
+			close(ch)
+		}
+	}
+
+	return ev.ID
+}
+
+// LastID returns the ID of the last published event.
+func (b *streamBroker) LastID() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.formatID(b.seq)
+}
+
+// Close disconnects all subscribers and rejects the new ones.
+func (b *streamBroker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	for ch := range b.subs {
+		delete(b.subs, ch)
+		close(ch)
+	}
+}
+
+func (b *streamBroker) formatID(seq uint64) string {
+	return fmt.Sprintf("%s-%d", b.instance, seq)
+}
+
+func (b *streamBroker) parseID(id string) (uint64, bool) {
+	instance, s, ok := strings.Cut(id, "-")
+	if !ok || instance != b.instance {
+		return 0, false
+	}
+	seq, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return seq, true
+}
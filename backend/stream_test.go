@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStreamBroker(t *testing.T) {
+	b := newStreamBroker(2)
+
+	first := b.Publish("test", []byte("1"))
+	b.Publish("test", []byte("2"))
+
+	// продолжение с известного события
+	sub, complete, err := b.Subscribe(first)
+	if err != nil || !complete {
+		t.Fatalf("Subscribe(%q) = %v, %v, want complete", first, complete, err)
+	}
+	if len(sub.Backlog) != 1 || string(sub.Backlog[0].Data) != "2" {
+		t.Errorf("backlog = %v, want event 2", sub.Backlog)
+	}
+
+	// события другого экземпляра и потерянные события требуют снимка
+	for _, id := range []string{"", "other-1", b.formatID(100)} {
+		sub, complete, err := b.Subscribe(id)
+		if err != nil || complete {
+			t.Errorf("Subscribe(%q) = %v, %v, want not complete", id, complete, err)
+		}
+		if sub != nil {
+			sub.Close()
+		}
+	}
+
+	// не больше maxSubs подписчиков
+	sub2, _, _ := b.Subscribe("")
+	if _, _, err := b.Subscribe(""); err != errTooManySubscribers {
+		t.Errorf("Subscribe() error = %v, want %v", err, errTooManySubscribers)
+	}
+	sub2.Close()
+
+	// медленный подписчик отключается
+	for i := 0; i < streamBufferSize+1; i++ {
+		b.Publish("test", nil)
+	}
+	n := 0
+	for range sub.Events {
+		n++
+	}
+	if n != streamBufferSize {
+		t.Errorf("received %d events before disconnect, want %d", n, streamBufferSize)
+	}
+	sub.Close() // повторное закрытие безопасно
+}
+
+func TestPingResultsStream(t *testing.T) {
+	re := &fakeRepo{hosts: []Host{{ID: 1, Name: "host1"}}}
+	ca := NewCache(re, defaultStreamSubscribers)
+	ctx := context.Background()
+
+	srv := httptest.NewServer(Logging(getPingResultsStreamHandler(ca)))
+	defer srv.Close()
+	defer ca.CloseStream()
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+
+	events := make(chan string)
+	go func() {
+		defer close(events)
+		sc := bufio.NewScanner(resp.Body)
+		for sc.Scan() {
+			if typ, ok := strings.CutPrefix(sc.Text(), "event: "); ok {
+				events <- typ
+			}
+		}
+	}()
+
+	next := func() string {
+		select {
+		case typ := <-events:
+			return typ
+		case <-time.After(time.Second):
+			return "timeout"
+		}
+	}
+
+	if typ := next(); typ != "snapshot" {
+		t.Fatalf("first event = %q, want snapshot", typ)
+	}
+
+	// неуспешные результаты кэш не меняют и не публикуются
+	err = ca.AddPingResults(ctx, []PingResult{
+		{HostID: 1, Time: time.Now(), Success: false},
+		{HostID: 1, Time: time.Now(), Rtt: time.Millisecond, Success: true},
+		{HostID: 1, Time: time.Now(), Rtt: 2 * time.Millisecond, Success: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if typ := next(); typ != "ping-result" {
+		t.Fatalf("event = %q, want ping-result", typ)
+	}
+	// один результат на хост за пачку
+	if typ := next(); typ != "timeout" {
+		t.Errorf("extra event %q", typ)
+	}
+
+	// добавление, изменение и удаление хоста публикуются
+	host, err := ca.AddHost(ctx, Host{Name: "host2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := "host3"
+	if _, err := ca.UpdateHost(ctx, host.ID, HostPatch{Name: &name}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ca.DeleteHost(ctx, host.ID); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"ping-result", "ping-result", "host-deleted"} {
+		if typ := next(); typ != want {
+			t.Errorf("event = %q, want %s", typ, want)
+		}
+	}
+
+	// перезагрузка кэша публикует snapshot
+	re.hosts = append(re.hosts, Host{ID: 10, Name: "host10"})
+	if err := ca.AddPingResults(ctx, []PingResult{{HostID: 10, Time: time.Now(), Success: true}}); err != nil {
+		t.Fatal(err)
+	}
+	if typ := next(); typ != "snapshot" {
+		t.Errorf("event = %q, want snapshot", typ)
+	}
+}
+
+func TestStreamRouteNotObserved(t *testing.T) {
+	for _, pattern := range []string{"GET  /pub/ping-results/stream", "GET  /pub/ping-results"} {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Pattern = pattern
+		observeHTTPRequest(r, 200, time.Now())
+	}
+
+	// поток открыт все время подписки, его длительность не записывается
+	if httpRequestDuration.DeleteLabelValues("GET", "GET /pub/ping-results/stream", "200") {
+		t.Error("stream request observed")
+	}
+	if !httpRequestDuration.DeleteLabelValues("GET", "GET /pub/ping-results", "200") {
+		t.Error("request not observed")
+	}
+}
@@ -1,6 +1,6 @@
 import React, { useEffect, useState } from 'react';
 import { format } from 'date-fns';
-import { getPingResults } from './api';
+import { streamPingResults } from './api';
 import './App.css';
 
 const formatTimestamp = (timestamp: string) => {
@@ -9,6 +9,7 @@ const formatTimestamp = (timestamp: string) => {
 };
 
 interface PingResult {
+    host_id: number;
     host_name: string;
     ip: string;
     time: string;
@@ -16,16 +17,53 @@ interface PingResult {
     success: boolean
 }
 
+// пауза перед новым подключением к потоку растет от 1 до 30 секунд
+const minReconnectDelay = 1000;
+const maxReconnectDelay = 30000;
+
 const App: React.FC = () => {
     const [results, setResults] = useState<PingResult[]>([]);
 
     useEffect(() => {
-        const fetchData = async () => {
-            const result = await getPingResults();
-            setResults(result.data.ping_results);
+        let events: EventSource;
+        let timer: ReturnType<typeof setTimeout> | undefined;
+        let delay = minReconnectDelay;
+
+        const connect = () => {
+            // EventSource переподключается сам после обрыва соединения, но не после ответа
+            // с ошибкой (502 при перезапуске backend, 503 при превышении числа подписчиков)
+            events = streamPingResults();
+            events.onerror = () => {
+                if (events.readyState !== EventSource.CLOSED) {
+                    return;
+                }
+                timer = setTimeout(connect, delay);
+                delay = Math.min(delay * 2, maxReconnectDelay);
+            };
+            events.addEventListener('snapshot', (e) => {
+                delay = minReconnectDelay;
+                setResults(JSON.parse(e.data).ping_results);
+            });
+            // результат нового хоста добавляется в конец таблицы
+            events.addEventListener('ping-result', (e) => {
+                const update: PingResult = JSON.parse(e.data);
+                setResults((results) =>
+                    results.some((result) => result.host_id === update.host_id)
+                        ? results.map((result) => (result.host_id === update.host_id ? update : result))
+                        : [...results, update],
+                );
+            });
+            events.addEventListener('host-deleted', (e) => {
+                const { host_id }: { host_id: number } = JSON.parse(e.data);
+                setResults((results) => results.filter((result) => result.host_id !== host_id));
+            });
+        };
+
+        connect();
+        return () => {
+            clearTimeout(timer);
+            events.close();
         };
-        fetchData();
-        setInterval(fetchData, 5000);
     }, []);
 
     return (
@@ -41,8 +79,8 @@ const App: React.FC = () => {
                     </tr>
                 </thead>
                 <tbody>
-                    {results.map((result, index) => (
-                        <tr key={index}>
+                    {results.map((result) => (
+                        <tr key={result.host_id}>
                             <td>{result.host_name}</td>
                             <td>{result.ip}</td>
                             <td className="rtt">
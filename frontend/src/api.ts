@@ -4,4 +4,5 @@ const api = axios.create({
     baseURL: '/api',
 });
 
-export const getPingResults = () => api.get('/ping-results');
\ No newline at end of file
+export const getPingResults = () => api.get('/ping-results');
+export const streamPingResults = () => new EventSource('/api/ping-results/stream');
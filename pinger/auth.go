@@ -0,0 +1,83 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Request signature headers, checked by the backend.
+const (
+	authKeyHeader       = "X-Auth-Key"
+	authTimestampHeader = "X-Auth-Timestamp"
+	authNonceHeader     = "X-Auth-Nonce"
+	authSignatureHeader = "X-Auth-Signature"
+)
+
+// authKey signs the requests to the backend with HMAC-SHA256. The key ID lets
+// the backend accept several keys while they are rotated.
+type authKey struct {
+	ID     string
+	Secret []byte
+}
+
+// parseAuthKey parses "id:secret".
+func parseAuthKey(s string) (*authKey, error) {
+	id, secret, ok := strings.Cut(s, ":")
+	if !ok || id == "" || secret == "" {
+		return nil, errors.New(`want "id:secret"`)
+	}
+	return &authKey{ID: id, Secret: []byte(secret)}, nil
+}
+
+// String returns the key ID, the secret is masked.
+func (k *authKey) String() string {
+	if k == nil || k.ID == "" {
+		return ""
+	}
+	return k.ID + ":xxxxx"
+}
+
+// Set replaces the key with the parsed one, empty s removes the key.
+func (k *authKey) Set(s string) error {
+	if s == "" {
+		*k = authKey{}
+		return nil
+	}
+	parsed, err := parseAuthKey(s)
+	if err != nil {
+		return err
+	}
+	*k = *parsed
+	return nil
+}
+
+// Sign adds the signature of the request with the given body. A request is
+// signed for every attempt, the backend rejects the replayed ones.
+func (k *authKey) Sign(req *http.Request, body []byte) {
+	var nonce [16]byte
+	rand.Read(nonce[:])
+
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	n := hex.EncodeToString(nonce[:])
+
+	req.Header.Set(authKeyHeader, k.ID)
+	req.Header.Set(authTimestampHeader, ts)
+	req.Header.Set(authNonceHeader, n)
+	req.Header.Set(authSignatureHeader, k.signature(req.Method, req.URL.RequestURI(), ts, n, body))
+}
+
+// signature is hex HMAC-SHA256 of method, URI, timestamp, nonce and hex SHA-256
+// of the body, separated by new lines.
+func (k *authKey) signature(method, uri, ts, nonce string, body []byte) string {
+	sum := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, k.Secret)
+	mac.Write([]byte(method + "\n" + uri + "\n" + ts + "\n" + nonce + "\n" + hex.EncodeToString(sum[:])))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// config is the pinger configuration. Every option is a flag "-name", a line
+// "name = value" of the config file and an environment variable NAME with
+// dashes replaced by underscores. The flags override the environment, the
+// environment overrides the file.
+type config struct {
+	BackendURL       string
+	BackendUpTimeout time.Duration
+	ShutdownTimeout  time.Duration
+	LogLevel         slog.Level
+	AuthKey          authKey // empty ID - requests to the backend are not signed
+
+	PingInterval         time.Duration
+	PingTimeout          time.Duration
+	DNSInterval          time.Duration // 0 - disabled
+	HostsRefreshInterval time.Duration // 0 - disabled
+	Privileged           bool
+	MaxConcurrentProbes  int // 0 - unlimited
+
+	BatchSize     int // 0 - number of hosts
+	BatchTimeout  time.Duration
+	QueueSize     int
+	QueueOverflow overflowPolicy
+	Retry         retryPolicy
+	SpoolDir      string // empty - undelivered results are dropped
+	SpoolMaxSize  int64  // bytes
+
+	DebugAddr string // empty - debug http server is disabled
+
+	flags *flag.FlagSet
+}
+
+// configFileEnv is the environment variable with the config file path, if the flag is not given.
+const configFileEnv = "CONFIG"
+
+// secretOptions are masked in the errors.
+var secretOptions = map[string]bool{
+	"auth-key": true,
+}
+
+func newConfigFlagSet(cfg *config) *flag.FlagSet {
+	fs := flag.NewFlagSet("pinger", flag.ContinueOnError)
+	fs.String("config", "", "config `file` with \"name = value\" lines")
+
+	fs.StringVar(&cfg.BackendURL, "backend-url", "http://backend:8080", "backend base URL")
+	fs.DurationVar(&cfg.BackendUpTimeout, "backend-up-timeout", 30*time.Second, "how long to wait for the backend on startup")
+	fs.DurationVar(&cfg.ShutdownTimeout, "shutdown-timeout", 30*time.Second, "graceful shutdown timeout")
+	fs.TextVar(&cfg.LogLevel, "log-level", slog.LevelInfo, "log level: debug, info, warn or error")
+	fs.Var(&cfg.AuthKey, "auth-key", "backend request `key` id:secret")
+
+	fs.DurationVar(&cfg.PingInterval, "ping-interval", 10*time.Second, "default probe interval of a host")
+	fs.DurationVar(&cfg.PingTimeout, "ping-timeout", 5*time.Second, "default probe timeout of a host")
+	fs.DurationVar(&cfg.DNSInterval, "dns-interval", 30*time.Second, "host name resolution interval, 0 - disabled")
+	fs.DurationVar(&cfg.HostsRefreshInterval, "hosts-refresh-interval", 30*time.Second, "host list refresh interval, 0 - disabled")
+	fs.BoolVar(&cfg.Privileged, "privileged", false, "use raw ICMP sockets, requires CAP_NET_RAW")
+	fs.IntVar(&cfg.MaxConcurrentProbes, "max-concurrent-probes", 0, "probes run at once, 0 - unlimited")
+
+	fs.IntVar(&cfg.BatchSize, "batch-size", 0, "results per batch, 0 - number of hosts")
+	fs.DurationVar(&cfg.BatchTimeout, "batch-timeout", 10*time.Millisecond, "how long to wait for a batch to fill")
+	fs.IntVar(&cfg.QueueSize, "send-queue-size", defaultQueueSize, "results waiting to be sent")
+	cfg.QueueOverflow = overflowBlock
+	fs.Var(&cfg.QueueOverflow, "send-queue-overflow", "full queue policy: block, drop-oldest or drop-newest")
+	fs.IntVar(&cfg.Retry.MaxAttempts, "send-max-attempts", defaultRetryPolicy.MaxAttempts, "batch delivery attempts")
+	fs.DurationVar(&cfg.Retry.Timeout, "send-timeout", defaultRetryPolicy.Timeout, "batch delivery attempt timeout")
+	fs.DurationVar(&cfg.Retry.MinBackoff, "send-min-backoff", defaultRetryPolicy.MinBackoff, "delay before the first retry")
+	fs.DurationVar(&cfg.Retry.MaxBackoff, "send-max-backoff", defaultRetryPolicy.MaxBackoff, "max delay between retries")
+	fs.Float64Var(&cfg.Retry.Jitter, "send-jitter", defaultRetryPolicy.Jitter, "randomized fraction of the retry delay, [0, 1]")
+	fs.StringVar(&cfg.SpoolDir, "spool-dir", "", "directory of undelivered batches, empty - they are dropped")
+	fs.Int64Var(&cfg.SpoolMaxSize, "spool-max-size", 64<<20, "spool size limit, bytes")
+
+	fs.StringVar(&cfg.DebugAddr, "debug-addr", "", "debug http server address, empty - disabled")
+
+	return fs
+}
+
+// loadConfig parses the flags of args and takes the options not given there
+// from the environment or the config file. All the invalid options are
+// reported at once.
+func loadConfig(args []string, lookupEnv func(string) (string, bool)) (*config, error) {
+	cfg := &config{}
+	fs := newConfigFlagSet(cfg)
+	cfg.flags = fs
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected arguments %q", fs.Args())
+	}
+
+	values, errs, err := pendingOptions(fs, lookupEnv)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range values {
+		if err := setOption(fs, v); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	errs = append(errs, cfg.validate()...)
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return cfg, nil
+}
+
+func (cfg *config) validate() []error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	u, err := url.Parse(cfg.BackendURL)
+	check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "",
+		"backend-url: want http(s)://host[:port]")
+	check(cfg.BackendUpTimeout > 0, "backend-up-timeout: must be positive")
+	check(cfg.ShutdownTimeout > 0, "shutdown-timeout: must be positive")
+	check(cfg.PingInterval > 0, "ping-interval: must be positive")
+	check(cfg.PingTimeout > 0, "ping-timeout: must be positive")
+	check(cfg.DNSInterval >= 0, "dns-interval: must not be negative")
+	check(cfg.HostsRefreshInterval >= 0, "hosts-refresh-interval: must not be negative")
+	check(cfg.MaxConcurrentProbes >= 0, "max-concurrent-probes: must not be negative")
+	check(cfg.BatchSize >= 0, "batch-size: must not be negative")
+	check(cfg.BatchTimeout > 0, "batch-timeout: must be positive")
+	check(cfg.QueueSize > 0, "send-queue-size: must be positive")
+	check(cfg.Retry.MaxAttempts >= 0, "send-max-attempts: must not be negative")
+	check(cfg.Retry.Timeout > 0, "send-timeout: must be positive")
+	check(cfg.Retry.MinBackoff >= 0, "send-min-backoff: must not be negative")
+	check(cfg.Retry.MaxBackoff >= cfg.Retry.MinBackoff, "send-max-backoff: must not be less than send-min-backoff")
+	check(cfg.Retry.Jitter >= 0 && cfg.Retry.Jitter <= 1, "send-jitter: must be in [0, 1]")
+	check(cfg.SpoolMaxSize > 0, "spool-max-size: must be positive")
+	return errs
+}
+
+// LogValue is the effective config, the auth key secret is masked by its String.
+func (cfg *config) LogValue() slog.Value {
+	var attrs []slog.Attr
+	cfg.flags.VisitAll(func(f *flag.Flag) {
+		if f.Name != "config" {
+			attrs = append(attrs, slog.String(f.Name, f.Value.String()))
+		}
+	})
+	return slog.GroupValue(attrs...)
+}
+
+// optionValue is an option value taken from the environment or the config file.
+type optionValue struct {
+	name   string
+	value  string
+	source string // variable name or file path
+}
+
+// pendingOptions returns the values of the options that are not given by the
+// flags, a variable wins over the file. DEBUG stands for LOG_LEVEL=debug, as
+// docker-compose passes it. Unknown options of the file are returned in errs.
+func pendingOptions(fs *flag.FlagSet, lookupEnv func(string) (string, bool)) (values []optionValue, errs []error, err error) {
+	fromFile := map[string]optionValue{}
+	path := fs.Lookup("config").Value.String()
+	if path == "" {
+		path, _ = lookupEnv(configFileEnv)
+	}
+	if path != "" {
+		lines, err := readConfigFile(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, kv := range lines {
+			if fs.Lookup(kv[0]) == nil || kv[0] == "config" {
+				errs = append(errs, fmt.Errorf("%s: unknown option %s", path, kv[0]))
+				continue
+			}
+			fromFile[kv[0]] = optionValue{name: kv[0], value: kv[1], source: path}
+		}
+	}
+
+	given := map[string]bool{"config": true}
+	fs.Visit(func(f *flag.Flag) { given[f.Name] = true })
+	fs.VisitAll(func(f *flag.Flag) {
+		if given[f.Name] {
+			return
+		}
+		env := strings.ToUpper(strings.ReplaceAll(f.Name, "-", "_"))
+		if s, ok := lookupEnv(env); ok {
+			values = append(values, optionValue{name: f.Name, value: s, source: env})
+		} else if _, ok := lookupEnv("DEBUG"); ok && f.Name == "log-level" {
+			values = append(values, optionValue{name: f.Name, value: "debug", source: "DEBUG"})
+		} else if v, ok := fromFile[f.Name]; ok {
+			values = append(values, v)
+		}
+	})
+	return values, errs, nil
+}
+
+// setOption sets the flag value. On error the flag keeps its default and the
+// value of a secret option is not shown.
+func setOption(fs *flag.FlagSet, v optionValue) error {
+	f := fs.Lookup(v.name)
+	if secretOptions[v.name] {
+		if err := f.Value.Set(v.value); err != nil {
+			return fmt.Errorf("%s: %s: %w", v.source, v.name, err)
+		}
+		return nil
+	}
+	def := f.Value.String()
+	if err := f.Value.Set(v.value); err != nil {
+		f.Value.Set(def)
+		return fmt.Errorf("%s: %s=%q: %w", v.source, v.name, v.value, err)
+	}
+	return nil
+}
+
+// readConfigFile returns the "name = value" lines of the file, empty lines and
+// # comments are skipped.
+func readConfigFile(path string) ([][2]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var lines [][2]string
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: want name = value", path, i+1)
+		}
+		lines = append(lines, [2]string{strings.TrimSpace(name), strings.TrimSpace(value)})
+	}
+	return lines, nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pinger.conf")
+	err := os.WriteFile(path, []byte(`
+# комментарий
+backend-url = http://file:8080
+ping-interval = 1s
+batch-size = 10
+send-queue-overflow = drop-oldest
+`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	env := map[string]string{
+		"CONFIG":        path,
+		"PING_INTERVAL": "2s",
+		"BATCH_SIZE":    "20",
+		"AUTH_KEY":      "k1:topsecret",
+	}
+	lookupEnv := func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	}
+
+	// флаги важнее переменных окружения, переменные окружения важнее файла
+	cfg, err := loadConfig([]string{"-batch-size", "30", "-privileged"}, lookupEnv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.BackendURL != "http://file:8080" || cfg.PingInterval != 2*time.Second || cfg.BatchSize != 30 {
+		t.Errorf("backend-url = %q, ping-interval = %v, batch-size = %d", cfg.BackendURL, cfg.PingInterval, cfg.BatchSize)
+	}
+	if !cfg.Privileged || cfg.QueueOverflow != overflowDropOldest || cfg.Retry != defaultRetryPolicy {
+		t.Errorf("privileged = %v, overflow = %q, retry = %+v", cfg.Privileged, cfg.QueueOverflow, cfg.Retry)
+	}
+	if cfg.AuthKey.ID != "k1" || string(cfg.AuthKey.Secret) != "topsecret" {
+		t.Errorf("auth-key = %+v", cfg.AuthKey)
+	}
+	if logged := cfg.LogValue().String(); strings.Contains(logged, "topsecret") {
+		t.Errorf("secret logged: %s", logged)
+	}
+
+	// неверные значения не заменяются значениями по умолчанию, все ошибки сообщаются сразу
+	env = map[string]string{"PING_INTERVAL": "abc", "SEND_JITTER": "2", "AUTH_KEY": "topsecret", "SEND_QUEUE_OVERFLOW": "wait"}
+	_, err = loadConfig([]string{"-backend-url", "backend:8080"}, lookupEnv)
+	if err == nil {
+		t.Fatal("error = nil")
+	}
+	for _, s := range []string{"ping-interval", "send-jitter", "auth-key", "send-queue-overflow", "backend-url"} {
+		if !strings.Contains(err.Error(), s) {
+			t.Errorf("error %q does not mention %s", err, s)
+		}
+	}
+	if strings.Contains(err.Error(), "topsecret") || strings.Contains(err.Error(), "must be positive") {
+		t.Errorf("unexpected error %q", err)
+	}
+
+	// DEBUG действует как LOG_LEVEL=debug, только если LOG_LEVEL не задана
+	for _, tt := range []struct {
+		env  map[string]string
+		want slog.Level
+	}{
+		{map[string]string{"DEBUG": ""}, slog.LevelDebug},
+		{map[string]string{"DEBUG": "", "LOG_LEVEL": "warn"}, slog.LevelWarn},
+	} {
+		env = tt.env
+		cfg, err := loadConfig(nil, lookupEnv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cfg.LogLevel != tt.want {
+			t.Errorf("env %v: log-level = %v, want %v", tt.env, cfg.LogLevel, tt.want)
+		}
+	}
+
+	if _, err := loadConfig([]string{"extra"}, lookupEnv); err == nil {
+		t.Error("extra argument: error = nil")
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"slices"
+	"time"
+)
+
+// resolveOnce resolves the host name and reports the resolution latency. If the
+// name has several addresses, the least one is reported, so the backend sees
+// a change of the address set rather than a different order of the answer.
+func resolveOnce(ctx context.Context, host Host, name string, timeout time.Duration, snd sender) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", name)
+	rtt := time.Since(start)
+	if ctx.Err() == context.Canceled {
+		return
+	}
+	if err != nil {
+		snd.Send(failedResult(host, probeDNS, "", start, errorReason("resolve", err)))
+		return
+	}
+
+	snd.Send(PingResult{
+		HostID:  host.ID,
+		Probe:   probeDNS,
+		IP:      slices.MinFunc(addrs, netip.Addr.Compare).Unmap().String(),
+		Time:    time.Now(),
+		Rtt:     rtt,
+		Success: true,
+	})
+}
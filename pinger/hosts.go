@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var errNotModified = errors.New("not modified")
+
+// getHostsTimeout limits the host list request, so a hung backend does not
+// block the pinger, e.g. its shutdown.
+const getHostsTimeout = 10 * time.Second
+
+// getHosts returns the host list at url and its ETag. If etag is not empty and
+// the list has not changed since, errNotModified is returned.
+func getHosts(ctx context.Context, url, etag string) ([]Host, string, error) {
+	ctx, cancel := context.WithTimeout(ctx, getHostsTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	if auth != nil {
+		auth.Sign(req, nil)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer func() {
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotModified:
+		return nil, etag, errNotModified
+	default:
+		return nil, "", fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	buf, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var request = struct {
+		Hosts []Host
+	}{}
+	if err := json.Unmarshal(buf, &request); err != nil {
+		slog.Error("can't unmarshal body", "body", unsafeString(buf))
+		return nil, "", err
+	}
+
+	return request.Hosts, resp.Header.Get("ETag"), nil
+}
+
+// hostRunner runs a loop for every host and keeps the set of running loops
+// in line with the host list.
+type hostRunner struct {
+	ctx   context.Context
+	run   func(ctx context.Context, host Host)
+	wg    sync.WaitGroup
+	loops map[int]hostLoop
+}
+
+type hostLoop struct {
+	host   Host
+	cancel context.CancelFunc
+}
+
+func newHostRunner(ctx context.Context, run func(ctx context.Context, host Host)) *hostRunner {
+	return &hostRunner{
+		ctx:   ctx,
+		run:   run,
+		loops: map[int]hostLoop{},
+	}
+}
+
+// Reconcile starts loops for new hosts and stops loops for removed or disabled ones.
+// The loop of a host is restarted if the host has been changed. Other loops are
+// not disturbed.
+func (hr *hostRunner) Reconcile(hosts []Host) {
+	actual := make(map[int]Host, len(hosts))
+	for _, host := range hosts {
+		if host.Enabled {
+			actual[host.ID] = host
+		}
+	}
+
+	for id, loop := range hr.loops {
+		if host, ok := actual[id]; !ok || host != loop.host {
+			slog.Info("stop host loop", "host", loop.host)
+			loop.cancel()
+			delete(hr.loops, id)
+		}
+	}
+
+	for id, host := range actual {
+		if _, ok := hr.loops[id]; ok {
+			continue
+		}
+		slog.Info("start host loop", "host", host)
+		ctx, cancel := context.WithCancel(hr.ctx)
+		hr.loops[id] = hostLoop{host: host, cancel: cancel}
+		hr.wg.Add(1)
+		go func() {
+			defer hr.wg.Done()
+			hr.run(ctx, host)
+		}()
+	}
+}
+
+// Wait waits for all loops to finish after the runner context is canceled.
+func (hr *hostRunner) Wait() {
+	hr.wg.Wait()
+}
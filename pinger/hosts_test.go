@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHostRunnerReconcile(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var (
+		mu      sync.Mutex
+		running = map[Host]int{}
+	)
+	runner := newHostRunner(ctx, func(ctx context.Context, host Host) {
+		mu.Lock()
+		running[host]++
+		mu.Unlock()
+
+		<-ctx.Done()
+
+		mu.Lock()
+		running[host]--
+		mu.Unlock()
+	})
+
+	// waitRunning ожидает, что запущены циклы ровно для указанных хостов
+	waitRunning := func(t *testing.T, want ...Host) {
+		t.Helper()
+		deadline := time.Now().Add(time.Second)
+		for {
+			mu.Lock()
+			ok := true
+			n := 0
+			for _, v := range running {
+				n += v
+			}
+			for _, host := range want {
+				ok = ok && running[host] == 1
+			}
+			ok = ok && n == len(want)
+			mu.Unlock()
+
+			if ok {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("expected running %v, received %d loops", want, n)
+			}
+			time.Sleep(2 * time.Millisecond)
+		}
+	}
+
+	db := Host{ID: 1, Name: "db", Enabled: true}
+	backend := Host{ID: 2, Name: "backend", Enabled: true}
+	frontend := Host{ID: 3, Name: "frontend", Enabled: true}
+	frontendHTTP := Host{ID: 3, Name: "http://frontend:4173/", Enabled: true}
+	frontendFast := Host{ID: 3, Name: "http://frontend:4173/", Interval: time.Second, Enabled: true}
+	frontendDisabled := Host{ID: 3, Name: "http://frontend:4173/", Interval: time.Second}
+
+	runner.Reconcile([]Host{db, backend})
+	waitRunning(t, db, backend)
+
+	// новый хост добавлен, удаленный остановлен
+	runner.Reconcile([]Host{db, frontend})
+	waitRunning(t, db, frontend)
+
+	// измененный хост перезапущен
+	runner.Reconcile([]Host{db, frontendHTTP})
+	waitRunning(t, db, frontendHTTP)
+
+	runner.Reconcile([]Host{db, frontendFast})
+	waitRunning(t, db, frontendFast)
+
+	// отключенный хост остановлен
+	runner.Reconcile([]Host{db, frontendDisabled})
+	waitRunning(t, db)
+
+	cancel()
+	runner.Wait()
+	waitRunning(t)
+}
+
+func TestHostWithDefaults(t *testing.T) {
+	tests := []struct {
+		host, want Host
+	}{
+		{Host{}, Host{Interval: 10 * time.Second, Timeout: 5 * time.Second, Count: 1}},
+		{Host{Interval: time.Second, Timeout: time.Second, Count: 3}, Host{Interval: time.Second, Timeout: time.Second, Count: 3}},
+		// отрицательные значения из базы заменяются значениями по умолчанию
+		{Host{Interval: -time.Second, Timeout: -1, Count: -1}, Host{Interval: 10 * time.Second, Timeout: 5 * time.Second, Count: 1}},
+		{Host{Interval: 2 * time.Second}, Host{Interval: 2 * time.Second, Timeout: 2 * time.Second, Count: 1}},
+	}
+	for _, tt := range tests {
+		if got := tt.host.withDefaults(10*time.Second, 5*time.Second); got != tt.want {
+			t.Errorf("%+v.withDefaults() = %+v, want %+v", tt.host, got, tt.want)
+		}
+	}
+}
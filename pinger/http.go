@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"strings"
+	"time"
+)
+
+const maxCheckBodySize = 1 << 20
+
+// httpCheckClient makes a new connection for every check, so the latency
+// includes connect and TLS handshake, and does not follow redirects.
+var httpCheckClient = &http.Client{
+	Transport: &http.Transport{
+		Proxy:             http.ProxyFromEnvironment,
+		DisableKeepAlives: true,
+	},
+	CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// httpCheckOnce requests the endpoint and reports the total latency (including
+// reading of the body) and the TLS handshake time. The check fails if the status
+// code is out of the expected range or the body does not contain the expected text.
+func httpCheckOnce(ctx context.Context, host Host, probe, url string, check httpCheck, timeout time.Duration, snd sender) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var (
+		ip       string
+		tlsStart time.Time
+		tlsTime  time.Duration
+	)
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			if addr, ok := info.Conn.RemoteAddr().(*net.TCPAddr); ok {
+				ip = addr.IP.String()
+			}
+		},
+		TLSHandshakeStart: func() {
+			tlsStart = time.Now()
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			tlsTime = time.Since(tlsStart)
+		},
+	}
+
+	req, err := http.NewRequestWithContext(httptrace.WithClientTrace(ctx, trace), check.method, url, nil)
+	if err != nil {
+		snd.Send(failedResult(host, probe, "", time.Now(), err.Error()))
+		return
+	}
+
+	start := time.Now()
+	resp, err := httpCheckClient.Do(req)
+	if ctx.Err() == context.Canceled {
+		return
+	}
+	if err != nil {
+		snd.Send(failedResult(host, probe, ip, start, errorReason("request", err)))
+		return
+	}
+	defer resp.Body.Close()
+
+	var body []byte
+	if check.body != "" {
+		body, err = io.ReadAll(io.LimitReader(resp.Body, maxCheckBodySize))
+	} else {
+		_, err = io.Copy(io.Discard, resp.Body)
+	}
+	rtt := time.Since(start)
+	if ctx.Err() == context.Canceled {
+		return
+	}
+
+	result := PingResult{
+		HostID:       host.ID,
+		Probe:        probe,
+		IP:           ip,
+		Time:         time.Now(),
+		Rtt:          rtt,
+		StatusCode:   resp.StatusCode,
+		TLSHandshake: tlsTime,
+		Success:      true,
+	}
+
+	switch {
+	case err != nil:
+		result.Reason = errorReason("read body", err)
+	case resp.StatusCode < check.statusMin || resp.StatusCode > check.statusMax:
+		result.Reason = fmt.Sprintf("unexpected status %d", resp.StatusCode)
+	case check.body != "" && !strings.Contains(string(body), check.body):
+		result.Reason = "body mismatch"
+	}
+	result.Success = result.Reason == ""
+
+	snd.Send(result)
+}
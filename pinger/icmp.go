@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	probing "github.com/prometheus-community/pro-bing"
+)
+
+// packetInterval is the interval between echo requests of one probe, the least
+// one allowed to non-root users by ping(8).
+const packetInterval = 200 * time.Millisecond
+
+// icmpPrivileged selects raw ICMP sockets, which require CAP_NET_RAW, instead
+// of the unprivileged UDP ones.
+var icmpPrivileged = false
+
+// pingOnce sends host.Count echo requests to the host and reports the outcome of
+// each. A reply is reported as soon as it arrives. A request that could not be
+// sent, or is left unanswered when the timeout expires, is reported as a failure
+// with the reason.
+//
+// If host.Aggregate is set, a single result with the statistics of all requests
+// is reported instead.
+//
+// NOTE: pro-bing silently drops ICMP error messages (destination unreachable etc.),
+// so an unreachable host is seen either as a send error or as a timeout.
+func pingOnce(ctx context.Context, host Host, addr string, snd sender) {
+	pinger, err := probing.NewPinger(addr)
+	if err != nil {
+		slog.Debug("can't create pinger", "error", err, "host", host.Name)
+		snd.Send(failedResult(host, probeICMP, "", time.Now(), "resolve: "+err.Error()))
+		return
+	}
+	pinger.SetPrivileged(icmpPrivileged)
+	ip := pinger.IPAddr().String()
+
+	pinger.Count = host.Count
+	pinger.Interval = packetInterval
+	pinger.Timeout = host.Timeout + time.Duration(host.Count-1)*packetInterval
+	pinger.RecordRtts = false
+	pinger.RecordTTLs = false
+
+	// All callbacks are called from the pinger run loop, which is finished
+	// when RunWithContext returns, so no locking is required here.
+	pending := map[int]time.Time{}
+	sendErrors := 0
+	lastSendError := ""
+
+	pinger.OnSend = func(pkt *probing.Packet) {
+		pending[pkt.Seq] = time.Now()
+	}
+
+	pinger.OnSendError = func(pkt *probing.Packet, err error) {
+		sendErrors++
+		lastSendError = "send: " + err.Error()
+		if !host.Aggregate {
+			snd.Send(failedResult(host, probeICMP, ip, time.Now(), lastSendError))
+		}
+	}
+
+	pinger.OnRecv = func(pkt *probing.Packet) {
+		delete(pending, pkt.Seq)
+		if host.Aggregate {
+			return
+		}
+		result := PingResult{
+			HostID:  host.ID,
+			Probe:   probeICMP,
+			IP:      pkt.Addr,
+			Time:    time.Now(),
+			Rtt:     pkt.Rtt,
+			Success: true,
+		}
+		snd.Send(result)
+	}
+
+	err = pinger.RunWithContext(ctx)
+	if ctx.Err() != nil {
+		// canceled by us, the host is not to blame
+		return
+	}
+	if err != nil && sendErrors == 0 {
+		slog.Debug("ping failed", "error", err, "host", host.Name)
+		snd.Send(failedResult(host, probeICMP, ip, time.Now(), "ping: "+err.Error()))
+		return
+	}
+
+	if host.Aggregate {
+		snd.Send(aggregateResult(host, ip, pinger.Statistics(), sendErrors, lastSendError))
+		return
+	}
+
+	for _, sent := range pending {
+		snd.Send(failedResult(host, probeICMP, ip, sent, "timeout"))
+	}
+}
+
+// aggregateResult makes the result of the whole probe. The requests that could not
+// be sent are counted as lost.
+func aggregateResult(host Host, ip string, stats *probing.Statistics, sendErrors int, lastSendError string) PingResult {
+	sent := stats.PacketsSent + sendErrors
+	result := PingResult{
+		HostID:      host.ID,
+		Probe:       probeICMP,
+		IP:          ip,
+		Time:        time.Now(),
+		PacketsSent: sent,
+		PacketsRecv: stats.PacketsRecv,
+		Success:     stats.PacketsRecv > 0,
+	}
+	if sent > 0 {
+		result.Loss = float64(sent-stats.PacketsRecv) / float64(sent) * 100
+	}
+
+	if result.Success {
+		result.Rtt = stats.AvgRtt
+		result.MinRtt = stats.MinRtt
+		result.MaxRtt = stats.MaxRtt
+		result.StdDevRtt = stats.StdDevRtt
+	} else if stats.PacketsSent == 0 && lastSendError != "" {
+		result.Reason = lastSendError
+	} else {
+		result.Reason = "timeout"
+	}
+
+	return result
+}
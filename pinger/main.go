@@ -3,103 +3,166 @@ package main
 import (
 	"cmp"
 	"context"
-	"encoding/json"
+	"errors"
+	"flag"
 	"io"
 	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
 	"unsafe"
-
-	probing "github.com/prometheus-community/pro-bing"
-)
-
-const (
-	// TODO: to config
-	shutdownTimeout  = 30 * time.Second
-	backendUpTimeout = 30 * time.Second
-
-	batchTimeout   = 10 * time.Millisecond
-	baseURL        = "http://backend:8080"
-	pingResultsURL = baseURL + "/ping-results"
-	hostsURL       = baseURL + "/hosts"
-	pingURL        = baseURL + "/ping"
 )
 
 var (
-	logLevel     = slog.LevelInfo
-	pingInterval = 10 * time.Second
+	auth *authKey // nil - requests to the backend are not signed
+
+	// probeSlots limits the probes run at once, nil - unlimited
+	probeSlots chan struct{}
 )
 
 func main() {
-	if _, ok := os.LookupEnv("DEBUG"); ok {
-		logLevel = slog.LevelDebug
+	cfg, err := loadConfig(os.Args[1:], os.LookupEnv)
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		slog.Error("invalid config", "error", err)
+		os.Exit(2)
 	}
-	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: logLevel})))
+	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: cfg.LogLevel})))
+	slog.Info("config", "config", cfg)
 
-	// TODO: to config
-	if s, ok := os.LookupEnv("PING_INTERVAL"); ok {
-		if v, err := time.ParseDuration(s); err != nil {
-			slog.Warn("can't parse PING_INTERVAL", "PING_INTERVAL", s)
-		} else {
-			pingInterval = v
-		}
+	if cfg.AuthKey.ID != "" {
+		auth = &cfg.AuthKey
+	} else {
+		slog.Warn("auth-key is not set, requests to the backend are not signed")
+	}
+	icmpPrivileged = cfg.Privileged
+	if cfg.MaxConcurrentProbes > 0 {
+		probeSlots = make(chan struct{}, cfg.MaxConcurrentProbes)
 	}
 
-	slog.Info("wait backend up...", "timeout", backendUpTimeout)
-	if err := waitBackend(backendUpTimeout); err != nil {
+	baseURL := strings.TrimSuffix(cfg.BackendURL, "/")
+	pingURL := baseURL + "/ping"
+	hostsURL := baseURL + "/hosts"
+	pingResultsURL := baseURL + "/ping-results"
+
+	if cfg.DebugAddr != "" {
+		// expvar is served on /debug/vars
+		go func() {
+			slog.Info("debug http server startup", "addr", cfg.DebugAddr)
+			if err := http.ListenAndServe(cfg.DebugAddr, nil); err != nil {
+				slog.Error("debug http server fail", "error", err)
+			}
+		}()
+	}
+
+	slog.Info("wait backend up...", "timeout", cfg.BackendUpTimeout)
+	if err := waitBackend(pingURL, cfg.BackendUpTimeout); err != nil {
 		slog.Error("backend up timeout expired", "lastError", err)
 		os.Exit(1)
 	}
 
-	hosts, err := getHosts()
+	hosts, etag, err := getHosts(context.Background(), hostsURL, "")
 	if err != nil {
 		slog.Error("can't get hosts", "error", err)
 		os.Exit(1)
 	}
 	if len(hosts) == 0 {
-		slog.Error("nothing to ping")
-		os.Exit(1)
+		slog.Warn("nothing to ping yet")
 	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	sender := newHTTPSender(pingResultsURL, len(hosts), batchTimeout)
-
-	var wg sync.WaitGroup
-	wg.Add(len(hosts))
+	senderOpts := httpSenderOptions{
+		QueueSize: cfg.QueueSize,
+		Overflow:  cfg.QueueOverflow,
+		Retry:     cfg.Retry,
+		Auth:      auth,
+	}
+	if cfg.SpoolDir != "" {
+		sp, err := openSpool(cfg.SpoolDir, cfg.SpoolMaxSize)
+		if err != nil {
+			slog.Error("can't open spool", "error", err, "dir", cfg.SpoolDir)
+			os.Exit(1)
+		}
+		defer sp.Close()
+		senderOpts.Spool = sp
+	}
 
-	for i := range hosts {
-		host := hosts[i]
-		go func() {
-			defer wg.Done()
-			pingLoop(ctx, host, pingInterval, sender)
-		}()
+	// without batch-size a batch is the results of one round over the hosts
+	batchSize := func(hosts []Host) int {
+		return cmp.Or(cfg.BatchSize, len(hosts))
 	}
+	sender := newHTTPSender(pingResultsURL, batchSize(hosts), cfg.BatchTimeout, senderOpts)
+
+	runner := newHostRunner(ctx, func(ctx context.Context, host Host) {
+		host = host.withDefaults(cfg.PingInterval, cfg.PingTimeout)
+
+		var wg sync.WaitGroup
+		if cfg.DNSInterval > 0 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				resolveLoop(ctx, host, cfg.DNSInterval, sender)
+			}()
+		}
+		pingLoop(ctx, host, sender)
+		wg.Wait()
+	})
+	runner.Reconcile(hosts)
 
-	slog.Info("pinger is started, ping-pong begins...", "interval", pingInterval)
+	slog.Info("pinger is started, ping-pong begins...", "interval", cfg.PingInterval, "timeout", cfg.PingTimeout,
+		"dnsInterval", cfg.DNSInterval, "hostsRefreshInterval", cfg.HostsRefreshInterval)
 
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
-	signal := <-c
 
-	slog.Info("shutdown by signal", "signal", signal, "timeout", shutdownTimeout)
-	time.AfterFunc(shutdownTimeout, func() {
+	var refresh <-chan time.Time
+	if cfg.HostsRefreshInterval > 0 {
+		tk := time.NewTicker(cfg.HostsRefreshInterval)
+		defer tk.Stop()
+		refresh = tk.C
+	}
+
+	var signal os.Signal
+	for signal == nil {
+		select {
+		case signal = <-c:
+		case <-refresh:
+			hosts, newEtag, err := getHosts(ctx, hostsURL, etag)
+			if err == errNotModified {
+				continue
+			}
+			if err != nil {
+				slog.Warn("can't refresh hosts", "error", err)
+				continue
+			}
+			etag = newEtag
+			sender.SetBatchSize(batchSize(hosts))
+			runner.Reconcile(hosts)
+		}
+	}
+
+	slog.Info("shutdown by signal", "signal", signal, "timeout", cfg.ShutdownTimeout)
+	time.AfterFunc(cfg.ShutdownTimeout, func() {
 		slog.Error("shutdown timeout expired")
 		os.Exit(1)
 	})
 	cancel()
 
-	wg.Wait()
+	runner.Wait()
 	sender.Close()
 	slog.Info("pinger stopped")
 }
 
-func waitBackend(timeout time.Duration) error {
+func waitBackend(pingURL string, timeout time.Duration) error {
 	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
 
@@ -145,59 +208,70 @@ func httpPing(ctx context.Context, url string) error {
 	return nil
 }
 
-func getHosts() ([]Host, error) {
-	resp, err := http.Get(hostsURL)
-	if err != nil {
-		return nil, err
-	}
-	defer func() {
-		io.Copy(io.Discard, resp.Body)
-		resp.Body.Close()
-	}()
+type sender interface {
+	Send(result PingResult)
+}
 
-	buf, err := io.ReadAll(resp.Body)
+func pingLoop(ctx context.Context, host Host, snd sender) {
+	target, err := parseTarget(host.Name)
 	if err != nil {
-		return nil, err
+		slog.Error("can't parse host name", "error", err, "host", host.Name)
+		return
 	}
 
-	var request = struct {
-		Hosts []Host
-	}{}
-	if err := json.Unmarshal(buf, &request); err != nil {
-		slog.Error("can't unmarshal body", "body", unsafeString(buf))
-		return nil, err
+	var probe func(ctx context.Context)
+	switch target.probe {
+	case probeICMP:
+		probe = func(ctx context.Context) { pingOnce(ctx, host, target.addr, snd) }
+	case probeTCP:
+		probe = func(ctx context.Context) { connectOnce(ctx, host, target.addr, host.Timeout, snd) }
+	case probeHTTP, probeHTTPS:
+		probe = func(ctx context.Context) {
+			httpCheckOnce(ctx, host, target.probe, target.addr, target.http, host.Timeout, snd)
+		}
 	}
 
-	return request.Hosts, nil
+	runProbe(ctx, host.Interval, probe)
 }
 
-type sender interface {
-	Send(result PingResult)
-}
-
-func pingLoop(ctx context.Context, host Host, interval time.Duration, snd sender) {
-	pinger, err := probing.NewPinger(host.Name)
+// resolveLoop tracks the addresses the host name is resolved to.
+func resolveLoop(ctx context.Context, host Host, interval time.Duration, snd sender) {
+	target, err := parseTarget(host.Name)
 	if err != nil {
-		slog.Error("can't create pinger", "error", err, "host", host.Name)
-		return
+		return // already logged by pingLoop
 	}
+	if net.ParseIP(target.host) != nil {
+		return // nothing to resolve
+	}
+
+	runProbe(ctx, interval, func(ctx context.Context) {
+		resolveOnce(ctx, host, target.host, host.Timeout, snd)
+	})
+}
 
-	pinger.Interval = interval
-	pinger.RecordRtts = false
-	pinger.RecordTTLs = false
+func runProbe(ctx context.Context, interval time.Duration, probe func(ctx context.Context)) {
+	tk := time.NewTicker(interval)
+	defer tk.Stop()
+
+	for {
+		if probeSlots != nil {
+			select {
+			case probeSlots <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+		}
+		probe(ctx)
+		if probeSlots != nil {
+			<-probeSlots
+		}
 
-	pinger.OnRecv = func(pkt *probing.Packet) {
-		result := PingResult{
-			HostID:  host.ID,
-			IP:      pkt.Addr,
-			Time:    time.Now(),
-			Rtt:     pkt.Rtt,
-			Success: true,
+		select {
+		case <-ctx.Done():
+			return
+		case <-tk.C:
 		}
-		snd.Send(result)
 	}
-
-	pinger.RunWithContext(ctx)
 }
 
 func unsafeString(b []byte) string {
@@ -0,0 +1,15 @@
+package main
+
+import "expvar"
+
+// senderStats are served by the debug http server on /debug/vars.
+var senderStats = expvar.NewMap("sender")
+
+const (
+	statBatchesSent     = "batches_sent"     // delivered batches
+	statBatchRetries    = "batch_retries"    // repeated delivery attempts
+	statBatchesFailed   = "batches_failed"   // batches failed after all attempts
+	statBatchesRejected = "batches_rejected" // batches rejected by the backend (4xx)
+	statQueueDepth      = "queue_depth"      // results waiting to be batched
+	statResultsDropped  = "results_dropped"  // results dropped on queue overflow
+)
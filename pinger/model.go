@@ -3,14 +3,45 @@ package main
 import "time"
 
 type Host struct {
-	ID   int    `json:"host_id"`
-	Name string `json:"host_name"`
+	ID        int           `json:"host_id"`
+	Name      string        `json:"host_name"`
+	Interval  time.Duration `json:"interval"`  // probe interval, 0 - default
+	Timeout   time.Duration `json:"timeout"`   // probe timeout, 0 - default
+	Count     int           `json:"count"`     // ICMP packets per probe
+	Aggregate bool          `json:"aggregate"` // report ICMP probe statistics instead of every reply
+	Enabled   bool          `json:"enabled"`
+}
+
+// withDefaults returns the host with unset or invalid (not positive) probe
+// settings replaced by the defaults, the timeout is cut to the interval.
+func (h Host) withDefaults(interval, timeout time.Duration) Host {
+	if h.Interval <= 0 {
+		h.Interval = interval
+	}
+	if h.Timeout <= 0 {
+		h.Timeout = timeout
+	}
+	h.Timeout = min(h.Timeout, h.Interval) // the probes must not overlap
+	h.Count = max(h.Count, 1)
+	return h
 }
 
 type PingResult struct {
-	HostID  int           `json:"host_id"`
-	IP      string        `json:"ip"`
-	Time    time.Time     `json:"time"`
-	Rtt     time.Duration `json:"rtt"`
-	Success bool          `json:"success"`
+	HostID       int           `json:"host_id"`
+	Probe        string        `json:"probe"` // icmp, tcp, http, https, dns
+	IP           string        `json:"ip"`
+	Time         time.Time     `json:"time"`
+	Rtt          time.Duration `json:"rtt"`                     // average for aggregated ICMP probe
+	StatusCode   int           `json:"status_code,omitempty"`   // http(s) only
+	TLSHandshake time.Duration `json:"tls_handshake,omitempty"` // https only
+	Success      bool          `json:"success"`
+	Reason       string        `json:"reason,omitempty"` // why the ping failed
+
+	// aggregated ICMP probe only
+	PacketsSent int           `json:"packets_sent,omitempty"`
+	PacketsRecv int           `json:"packets_recv,omitempty"`
+	Loss        float64       `json:"loss,omitempty"` // %
+	MinRtt      time.Duration `json:"min_rtt,omitempty"`
+	MaxRtt      time.Duration `json:"max_rtt,omitempty"`
+	StdDevRtt   time.Duration `json:"stddev_rtt,omitempty"`
 }
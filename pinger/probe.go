@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	probeICMP  = "icmp"
+	probeTCP   = "tcp"
+	probeHTTP  = "http"
+	probeHTTPS = "https"
+	probeDNS   = "dns"
+)
+
+type target struct {
+	probe string
+	addr  string
+	host  string // host name or IP to be resolved
+	http  httpCheck
+}
+
+// httpCheck is what is expected from an HTTP endpoint.
+type httpCheck struct {
+	method    string
+	statusMin int
+	statusMax int
+	body      string // substring of the response body, if not empty
+}
+
+// parseTarget selects the probe by the host name:
+//
+//	host            - ICMP echo
+//	tcp://host:port - TCP connect
+//	http(s)://host:port/path#method=GET&status=200-399&body=text - HTTP(S) request
+//
+// The fragment of an HTTP(S) URL is never sent, so it holds the check options.
+// All of them are optional, the defaults are shown above.
+func parseTarget(name string) (target, error) {
+	scheme, rest, ok := strings.Cut(name, "://")
+	if !ok {
+		return target{probe: probeICMP, addr: name, host: name}, nil
+	}
+
+	switch scheme {
+	case probeTCP:
+		host, port, err := net.SplitHostPort(rest)
+		if err != nil {
+			return target{}, err
+		}
+		if host == "" {
+			return target{}, fmt.Errorf("%s: host required", name)
+		}
+		if n, err := strconv.ParseUint(port, 10, 16); err != nil || n == 0 {
+			return target{}, fmt.Errorf("%s: invalid port", name)
+		}
+		return target{probe: probeTCP, addr: rest, host: host}, nil
+
+	case probeHTTP, probeHTTPS:
+		u, err := url.Parse(name)
+		if err != nil {
+			return target{}, err
+		}
+		if u.Hostname() == "" {
+			return target{}, fmt.Errorf("%s: host required", name)
+		}
+		check, err := parseHTTPCheck(u.Fragment)
+		if err != nil {
+			return target{}, fmt.Errorf("%s: %w", name, err)
+		}
+		u.Fragment = ""
+		return target{probe: scheme, addr: u.String(), host: u.Hostname(), http: check}, nil
+	}
+
+	return target{}, fmt.Errorf("%s: unsupported probe %q", name, scheme)
+}
+
+func parseHTTPCheck(fragment string) (httpCheck, error) {
+	check := httpCheck{
+		method:    "GET",
+		statusMin: 200,
+		statusMax: 399,
+	}
+
+	opts, err := url.ParseQuery(fragment)
+	if err != nil {
+		return httpCheck{}, err
+	}
+
+	for k, v := range opts {
+		switch k {
+		case "method":
+			check.method = strings.ToUpper(v[0])
+		case "status":
+			lo, hi, ok := strings.Cut(v[0], "-")
+			if !ok {
+				hi = lo
+			}
+			check.statusMin, err = strconv.Atoi(lo)
+			if err != nil {
+				return httpCheck{}, fmt.Errorf("invalid status %q", v[0])
+			}
+			check.statusMax, err = strconv.Atoi(hi)
+			if err != nil || check.statusMin > check.statusMax {
+				return httpCheck{}, fmt.Errorf("invalid status %q", v[0])
+			}
+		case "body":
+			check.body = v[0]
+		default:
+			return httpCheck{}, fmt.Errorf("unknown option %q", k)
+		}
+	}
+
+	return check, nil
+}
+
+func failedResult(host Host, probe, ip string, tm time.Time, reason string) PingResult {
+	return PingResult{
+		HostID: host.ID,
+		Probe:  probe,
+		IP:     ip,
+		Time:   tm,
+		Reason: reason,
+	}
+}
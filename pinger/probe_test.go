@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	probing "github.com/prometheus-community/pro-bing"
+)
+
+func TestParseTarget(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    target
+		wantErr bool
+	}{
+		{"db", target{probe: probeICMP, addr: "db", host: "db"}, false},
+		{"10.0.0.1", target{probe: probeICMP, addr: "10.0.0.1", host: "10.0.0.1"}, false},
+		{"tcp://backend:8080", target{probe: probeTCP, addr: "backend:8080", host: "backend"}, false},
+		{"tcp://backend", target{}, true},
+		{"tcp://:8080", target{}, true},
+		{"tcp://backend:http", target{}, true},
+		{"tcp://backend:0", target{}, true},
+		{"udp://backend:53", target{}, true},
+		{"http://backend:8080/ping", target{
+			probe: probeHTTP,
+			addr:  "http://backend:8080/ping",
+			host:  "backend",
+			http:  httpCheck{method: "GET", statusMin: 200, statusMax: 399},
+		}, false},
+		{"https://example.com/health?full=1#method=head&status=200-204&body=ok", target{
+			probe: probeHTTPS,
+			addr:  "https://example.com/health?full=1",
+			host:  "example.com",
+			http:  httpCheck{method: "HEAD", statusMin: 200, statusMax: 204, body: "ok"},
+		}, false},
+		{"http://backend:8080/ping#status=204", target{
+			probe: probeHTTP,
+			addr:  "http://backend:8080/ping",
+			host:  "backend",
+			http:  httpCheck{method: "GET", statusMin: 204, statusMax: 204},
+		}, false},
+		{"http://backend/#status=299-200", target{}, true},
+		{"http://backend/#timeout=1s", target{}, true},
+		{"http:///ping", target{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseTarget(tt.name)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseTarget() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseTarget() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConnectOnce(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	host := Host{ID: 1, Name: "tcp://" + ln.Addr().String()}
+
+	t.Run("connected", func(t *testing.T) {
+		rec := &resultRecorder{}
+		connectOnce(context.Background(), host, ln.Addr().String(), time.Second, rec)
+
+		res := rec.single(t)
+		if !res.Success || res.IP != "127.0.0.1" || res.HostID != host.ID {
+			t.Errorf("unexpected result %+v", res)
+		}
+	})
+
+	t.Run("refused", func(t *testing.T) {
+		addr := ln.Addr().String()
+		ln.Close()
+
+		rec := &resultRecorder{}
+		connectOnce(context.Background(), host, addr, time.Second, rec)
+
+		res := rec.single(t)
+		if res.Success || res.Reason != "refused" {
+			t.Errorf("unexpected result %+v", res)
+		}
+	})
+}
+
+func TestHTTPCheckOnce(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/broken" {
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte("pong"))
+	}))
+	defer ts.Close()
+
+	host := Host{ID: 1}
+	tests := []struct {
+		name       string
+		url        string
+		check      httpCheck
+		wantReason string
+	}{
+		{"ok", ts.URL + "/ping", httpCheck{method: "GET", statusMin: 200, statusMax: 399, body: "pong"}, ""},
+		{"bad status", ts.URL + "/broken", httpCheck{method: "GET", statusMin: 200, statusMax: 399}, "unexpected status 500"},
+		{"body mismatch", ts.URL + "/ping", httpCheck{method: "GET", statusMin: 200, statusMax: 399, body: "ping"}, "body mismatch"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := &resultRecorder{}
+			httpCheckOnce(context.Background(), host, probeHTTP, tt.url, tt.check, time.Second, rec)
+
+			res := rec.single(t)
+			if res.Success != (tt.wantReason == "") || res.Reason != tt.wantReason {
+				t.Errorf("unexpected result %+v", res)
+			}
+			if res.Probe != probeHTTP || res.IP != "127.0.0.1" || res.StatusCode == 0 || res.Rtt == 0 {
+				t.Errorf("unexpected result %+v", res)
+			}
+		})
+	}
+}
+
+func TestResolveOnce(t *testing.T) {
+	host := Host{ID: 1, Name: "localhost"}
+
+	rec := &resultRecorder{}
+	resolveOnce(context.Background(), host, "localhost", time.Second, rec)
+
+	res := rec.single(t)
+	if !res.Success || res.Probe != probeDNS || res.IP != "127.0.0.1" {
+		t.Errorf("unexpected result %+v", res)
+	}
+}
+
+func TestAggregateResult(t *testing.T) {
+	host := Host{ID: 1, Count: 4, Aggregate: true}
+	ms := time.Millisecond
+
+	t.Run("partial loss", func(t *testing.T) {
+		stats := &probing.Statistics{PacketsSent: 3, PacketsRecv: 2, MinRtt: 1 * ms, AvgRtt: 2 * ms, MaxRtt: 3 * ms, StdDevRtt: ms}
+		got := aggregateResult(host, "10.0.0.1", stats, 1, "send: network is unreachable")
+		if !got.Success || got.PacketsSent != 4 || got.PacketsRecv != 2 || got.Loss != 50 ||
+			got.Rtt != 2*ms || got.MinRtt != ms || got.MaxRtt != 3*ms || got.StdDevRtt != ms || got.Reason != "" {
+			t.Errorf("unexpected result %+v", got)
+		}
+	})
+
+	t.Run("all lost", func(t *testing.T) {
+		stats := &probing.Statistics{PacketsSent: 4}
+		got := aggregateResult(host, "10.0.0.1", stats, 0, "")
+		if got.Success || got.Loss != 100 || got.Reason != "timeout" {
+			t.Errorf("unexpected result %+v", got)
+		}
+	})
+
+	t.Run("nothing sent", func(t *testing.T) {
+		stats := &probing.Statistics{}
+		got := aggregateResult(host, "10.0.0.1", stats, 4, "send: network is unreachable")
+		if got.Success || got.Loss != 100 || got.Reason != "send: network is unreachable" {
+			t.Errorf("unexpected result %+v", got)
+		}
+	})
+}
+
+// resultRecorder записывает результаты, переданные в Send
+type resultRecorder struct {
+	mu      sync.Mutex
+	results []PingResult
+}
+
+func (r *resultRecorder) Send(result PingResult) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.results = append(r.results, result)
+}
+
+func (r *resultRecorder) single(t *testing.T) PingResult {
+	t.Helper()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.results) != 1 {
+		t.Fatalf("expected 1 result, received %d", len(r.results))
+	}
+	return r.results[0]
+}
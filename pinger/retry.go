@@ -0,0 +1,55 @@
+package main
+
+import (
+	"errors"
+	"math/rand/v2"
+	"net/http"
+	"time"
+)
+
+const defaultSendTimeout = 2 * time.Second
+
+// retryPolicy defines how a batch delivery is retried.
+type retryPolicy struct {
+	MaxAttempts int           // including the first one, 0 or 1 - no retries
+	Timeout     time.Duration // of a single attempt, 0 - defaultSendTimeout
+	MinBackoff  time.Duration // before the first retry, doubled for each next one
+	MaxBackoff  time.Duration
+	Jitter      float64 // fraction of the backoff to be randomized, [0, 1]
+}
+
+var defaultRetryPolicy = retryPolicy{
+	MaxAttempts: 3,
+	Timeout:     defaultSendTimeout,
+	MinBackoff:  100 * time.Millisecond,
+	MaxBackoff:  2 * time.Second,
+	Jitter:      0.2,
+}
+
+// backoff returns the delay before the retry number n (starting from 1).
+func (p retryPolicy) backoff(n int) time.Duration {
+	d := p.MinBackoff
+	for i := 1; i < n && d < p.MaxBackoff; i++ {
+		d *= 2
+	}
+	d = min(d, p.MaxBackoff)
+
+	// the jitter spreads the retries of many pingers over time
+	return d - time.Duration(p.Jitter*rand.Float64()*float64(d))
+}
+
+// isRetryable reports whether the delivery may succeed if it is repeated: the
+// network errors, 5xx, 429 and 401 are retried, other error answers never are.
+// 401 is fixed on the backend side (a key not added yet, a clock skew), so the
+// batches are kept until then.
+func isRetryable(err error) bool {
+	var statusErr *statusError
+	if errors.As(err, &statusErr) {
+		switch statusErr.StatusCode {
+		case http.StatusTooManyRequests, http.StatusUnauthorized:
+			return true
+		}
+		return statusErr.StatusCode >= 500
+	}
+	return true
+}
@@ -2,37 +2,136 @@ package main
 
 import (
 	"bytes"
+	"cmp"
 	"context"
 	"encoding/json"
+	"expvar"
+	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
+	"sync/atomic"
 	"time"
 )
 
+const (
+	spoolReplayInterval = 5 * time.Second
+	spoolReplayLimit    = 100 // batches per replay, not to hold up new results
+	defaultQueueSize    = 1000
+)
+
+// overflowPolicy defines what Send does when the queue is full.
+type overflowPolicy string
+
+const (
+	overflowBlock      overflowPolicy = "block"       // wait for a free place
+	overflowDropOldest overflowPolicy = "drop-oldest" // drop the oldest queued result
+	overflowDropNewest overflowPolicy = "drop-newest" // drop the result being sent
+)
+
+func parseOverflowPolicy(s string) (overflowPolicy, error) {
+	switch p := overflowPolicy(s); p {
+	case overflowBlock, overflowDropOldest, overflowDropNewest:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown overflow policy %q", s)
+}
+
+func (p overflowPolicy) String() string { return string(p) }
+
+func (p *overflowPolicy) Set(s string) error {
+	v, err := parseOverflowPolicy(s)
+	if err != nil {
+		return err
+	}
+	*p = v
+	return nil
+}
+
+type httpSenderOptions struct {
+	QueueSize int            // 0 - defaultQueueSize
+	Overflow  overflowPolicy // "" - block
+
+	Retry retryPolicy
+
+	// Spool keeps the batches that could not be delivered until the backend is
+	// reachable again. If nil, such batches are dropped.
+	Spool *spool
+
+	// Auth signs the requests. If nil, the requests are not signed.
+	Auth *authKey
+}
+
 type httpSender struct {
-	done  chan struct{}
-	url   string
-	c     chan PingResult
-	batch []PingResult
+	done     chan struct{}
+	closing  chan struct{} // closed by Close, cuts the retry backoff short
+	url      string
+	c        chan PingResult // bounded queue
+	overflow overflowPolicy
+	batch    []PingResult
+	size     atomic.Int64 // results per batch, see SetBatchSize
+	retry    retryPolicy
+	spool    *spool
+	auth     *authKey
 }
 
-func newHTTPSender(url string, batchSize int, batchTimeout time.Duration) *httpSender {
+func newHTTPSender(url string, batchSize int, batchTimeout time.Duration, opts httpSenderOptions) *httpSender {
 	snd := &httpSender{
-		done:  make(chan struct{}),
-		url:   url,
-		c:     make(chan PingResult),
-		batch: make([]PingResult, 0, batchSize),
+		done:     make(chan struct{}),
+		closing:  make(chan struct{}),
+		url:      url,
+		c:        make(chan PingResult, cmp.Or(opts.QueueSize, defaultQueueSize)),
+		overflow: cmp.Or(opts.Overflow, overflowBlock),
+		batch:    make([]PingResult, 0, batchSize),
+		retry:    opts.Retry,
+		spool:    opts.Spool,
+		auth:     opts.Auth,
 	}
+	snd.SetBatchSize(batchSize)
+	senderStats.Set(statQueueDepth, expvar.Func(func() any { return len(snd.c) }))
 	go snd.serve(batchTimeout)
 	return snd
 }
 
+// SetBatchSize changes the number of results per batch, it takes effect from
+// the next batch.
+func (s *httpSender) SetBatchSize(n int) {
+	s.size.Store(int64(max(n, 1)))
+}
+
+// Send puts the result to the queue. If the queue is full, Send blocks or drops
+// a result according to the overflow policy, so a slow backend does not hold up
+// the probes.
 func (s *httpSender) Send(result PingResult) {
-	s.c <- result
+	switch s.overflow {
+	case overflowDropNewest:
+		select {
+		case s.c <- result:
+		default:
+			senderStats.Add(statResultsDropped, 1)
+		}
+
+	case overflowDropOldest:
+		for {
+			select {
+			case s.c <- result:
+				return
+			default:
+			}
+			select {
+			case <-s.c:
+				senderStats.Add(statResultsDropped, 1)
+			default:
+			}
+		}
+
+	default:
+		s.c <- result
+	}
 }
 
 func (s *httpSender) Close() {
+	close(s.closing)
 	close(s.c)
 	<-s.done
 }
@@ -42,8 +141,24 @@ func (s *httpSender) serve(batchTimeout time.Duration) {
 
 	tm := time.NewTimer(0)
 
+	var replay <-chan time.Time
+	if s.spool != nil {
+		tk := time.NewTicker(spoolReplayInterval)
+		defer tk.Stop()
+		replay = tk.C
+	}
+
 	for {
-		result, ok := <-s.c
+		var (
+			result PingResult
+			ok     bool
+		)
+		select {
+		case result, ok = <-s.c:
+		case <-replay:
+			s.replay()
+			continue
+		}
 		if !ok {
 			return
 		}
@@ -51,7 +166,7 @@ func (s *httpSender) serve(batchTimeout time.Duration) {
 
 		tm.Reset(batchTimeout)
 	waitLoop:
-		for len(s.batch) < cap(s.batch) {
+		for len(s.batch) < int(s.size.Load()) {
 			select {
 			case <-tm.C:
 				break waitLoop
@@ -80,18 +195,116 @@ func (s *httpSender) sendBatch() {
 		return
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	if s.spool != nil && !s.spool.Empty() {
+		// the spooled batches go first to keep the order
+		s.spoolBatch(jsonData)
+		s.replay()
+		return
+	}
+
+	if err := s.deliver(jsonData, s.retry.MaxAttempts); err != nil {
+		slog.Error("can't send batch", "error", err)
+		if isRetryable(err) {
+			s.spoolBatch(jsonData)
+		}
+	}
+}
+
+func (s *httpSender) spoolBatch(data []byte) {
+	if s.spool == nil {
+		return
+	}
+	if err := s.spool.Put(data); err != nil {
+		slog.Error("can't spool batch", "error", err)
+	}
+}
+
+// replay delivers the spooled batches oldest first until the spool is empty or
+// the delivery fails. Each batch is tried once, the failed one is tried again
+// on the next replay, so a down backend does not hold up the queue.
+func (s *httpSender) replay() {
+	for range spoolReplayLimit {
+		data, ok, err := s.spool.Peek()
+		if err != nil {
+			slog.Error("can't read spool", "error", err)
+			return
+		}
+		if !ok {
+			return
+		}
+
+		if err := s.deliver(data, 1); err != nil {
+			if isRetryable(err) {
+				slog.Debug("can't replay spooled batch", "error", err)
+				return
+			}
+			slog.Error("spooled batch rejected", "error", err)
+		}
+
+		if err := s.spool.Pop(); err != nil {
+			slog.Error("can't remove batch from spool", "error", err)
+			return
+		}
+	}
+}
+
+// statusError is an error answer of the backend.
+type statusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("remote return error %d: %s", e.StatusCode, e.Body)
+}
+
+// deliver posts the batch in up to attempts attempts with the backoff of the
+// retry policy between them. After Close the batch is not retried.
+func (s *httpSender) deliver(data []byte, attempts int) error {
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = s.post(data)
+		if err == nil {
+			senderStats.Add(statBatchesSent, 1)
+			return nil
+		}
+		if !isRetryable(err) {
+			senderStats.Add(statBatchesRejected, 1)
+			return err
+		}
+		if attempt >= attempts {
+			senderStats.Add(statBatchesFailed, 1)
+			return err
+		}
+
+		backoff := s.retry.backoff(attempt)
+		slog.Debug("retry batch delivery", "error", err, "attempt", attempt, "backoff", backoff)
+		tm := time.NewTimer(backoff)
+		select {
+		case <-tm.C:
+		case <-s.closing:
+			tm.Stop()
+			senderStats.Add(statBatchesFailed, 1)
+			return err
+		}
+		senderStats.Add(statBatchRetries, 1)
+	}
+}
+
+func (s *httpSender) post(data []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), cmp.Or(s.retry.Timeout, defaultSendTimeout))
 	defer cancel()
-	httpReq, err := http.NewRequestWithContext(ctx, "POST", s.url, bytes.NewBuffer(jsonData))
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", s.url, bytes.NewReader(data))
 	if err != nil {
-		slog.Error("can't create http request", "error", err)
-		return
+		return fmt.Errorf("can't create http request: %w", err)
+	}
+	if s.auth != nil {
+		s.auth.Sign(httpReq, data)
 	}
 
 	httpResp, err := http.DefaultClient.Do(httpReq)
 	if err != nil {
-		slog.Error("can't do http request", "error", err)
-		return
+		return err
 	}
 	defer func() {
 		io.Copy(io.Discard, httpResp.Body)
@@ -100,7 +313,8 @@ func (s *httpSender) sendBatch() {
 
 	if httpResp.StatusCode >= 400 {
 		body, _ := io.ReadAll(httpResp.Body)
-		slog.Error("remote return error", "statusCode", httpResp.StatusCode, "body", unsafeString(body))
-		return
+		return &statusError{StatusCode: httpResp.StatusCode, Body: unsafeString(body)}
 	}
+
+	return nil
 }
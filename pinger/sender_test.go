@@ -2,9 +2,13 @@ package main
 
 import (
 	"encoding/json"
+	"expvar"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -25,7 +29,7 @@ func TestHTTPSenderBatching(t *testing.T) {
 			w.WriteHeader(http.StatusOK)
 		}))
 
-		sender := newHTTPSender(ts.URL, batchSize, batchTimeout)
+		sender := newHTTPSender(ts.URL, batchSize, batchTimeout, httpSenderOptions{})
 		return ts, sender, recorder
 	}
 
@@ -92,6 +96,23 @@ func TestHTTPSenderBatching(t *testing.T) {
 		}
 	})
 
+	t.Run("batch size is changed", func(t *testing.T) {
+		batchTimeout := 50 * time.Millisecond
+		ts, sender, recorder := setup(1, batchTimeout)
+		defer ts.Close()
+
+		// число хостов выросло после запуска
+		sender.SetBatchSize(3)
+		for i := 0; i < 3; i++ {
+			sender.Send(PingResult{HostID: i})
+		}
+
+		recorder.waitBatches(t, 1, batchTimeout/2)
+		if len(recorder.batches[0]) != 3 {
+			t.Errorf("expected batch of 3 elements, received %d", len(recorder.batches[0]))
+		}
+	})
+
 	t.Run("large data package", func(t *testing.T) {
 		batchSize := 3
 		batchTimeout := 10 * time.Millisecond
@@ -117,6 +138,258 @@ func TestHTTPSenderBatching(t *testing.T) {
 	})
 }
 
+// TestHTTPSenderSpool проверяет доставку батчей, сохраненных на диск во время недоступности backend
+func TestHTTPSenderSpool(t *testing.T) {
+	recorder := &batchRecorder{}
+	var down atomic.Bool
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if down.Load() {
+			http.Error(w, "service unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		var req struct {
+			PingResults []PingResult `json:"ping_results"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Error("decode error:", err)
+		}
+		recorder.add(req.PingResults)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	sp, err := openSpool(t.TempDir(), 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sp.Close()
+
+	batchTimeout := 10 * time.Millisecond
+	sender := newHTTPSender(ts.URL, 1, batchTimeout, httpSenderOptions{Spool: sp})
+	defer sender.Close()
+
+	// backend недоступен: батчи сохраняются на диск
+	down.Store(true)
+	sender.Send(PingResult{HostID: 1})
+	sender.Send(PingResult{HostID: 2})
+	deadline := time.Now().Add(time.Second)
+	for size, _ := sp.Size(); size == 0 || sp.Empty(); size, _ = sp.Size() {
+		if time.Now().After(deadline) {
+			t.Fatal("batches are not spooled")
+		}
+		time.Sleep(2 * time.Millisecond)
+	}
+
+	// backend доступен: сначала доставляются сохраненные батчи
+	down.Store(false)
+	sender.Send(PingResult{HostID: 3})
+	sender.Send(PingResult{HostID: 4})
+
+	recorder.waitBatches(t, 4, time.Second)
+	for i, batch := range recorder.batches {
+		if len(batch) != 1 || batch[0].HostID != i+1 {
+			t.Errorf("batch %d: unexpected %v", i, batch)
+		}
+	}
+	if !sp.Empty() {
+		t.Error("spool is not empty")
+	}
+}
+
+// TestHTTPSenderReplayDown проверяет, что недоступный backend не задерживает очередь:
+// сохраненные батчи не повторяются, ожидание повтора прерывается при остановке
+func TestHTTPSenderReplayDown(t *testing.T) {
+	var requests atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		http.Error(w, "service unavailable", http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	sp, err := openSpool(t.TempDir(), 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sp.Close()
+	for range 3 {
+		mustPut(t, sp, `{"ping_results":[]}`)
+	}
+
+	policy := retryPolicy{MaxAttempts: 3, Timeout: time.Second, MinBackoff: time.Hour, MaxBackoff: time.Hour}
+	sender := newHTTPSender(ts.URL, 1, time.Millisecond, httpSenderOptions{Retry: policy, Spool: sp})
+
+	// одна попытка на повтор, батчи остаются на диске
+	sender.replay()
+	if n := requests.Load(); n != 1 {
+		t.Errorf("expected 1 request, received %d", n)
+	}
+
+	// новый батч сохраняется на диск, Close не ждет паузы перед повтором
+	for range 3 {
+		mustPop(t, sp, `{"ping_results":[]}`)
+	}
+	sender.Send(PingResult{HostID: 1})
+	deadline := time.Now().Add(time.Second)
+	for requests.Load() < 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("batch is not sent")
+		}
+		time.Sleep(2 * time.Millisecond)
+	}
+	closed := make(chan struct{})
+	go func() {
+		sender.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("Close() is blocked by the backoff")
+	}
+	if sp.Empty() {
+		t.Error("batch is not spooled")
+	}
+}
+
+// TestHTTPSenderRetry проверяет повтор доставки батча в зависимости от ответа backend
+func TestHTTPSenderRetry(t *testing.T) {
+	policy := retryPolicy{
+		MaxAttempts: 3,
+		Timeout:     time.Second,
+		MinBackoff:  time.Millisecond,
+		MaxBackoff:  time.Millisecond,
+	}
+
+	tests := []struct {
+		name         string
+		statusCodes  []int // ответы backend на последовательные запросы
+		wantRequests int
+		wantErr      bool
+	}{
+		{"success", []int{200}, 1, false},
+		{"retry on 5xx", []int{503, 500, 200}, 3, false},
+		{"retry on 429", []int{429, 200}, 2, false},
+		{"retry on 401", []int{401, 200}, 2, false},
+		{"attempts exhausted", []int{503, 503, 503, 200}, 3, true},
+		{"no retry on 400", []int{400, 200}, 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests atomic.Int32
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := int(requests.Add(1)) - 1
+				w.WriteHeader(tt.statusCodes[min(n, len(tt.statusCodes)-1)])
+			}))
+			defer ts.Close()
+
+			sender := newHTTPSender(ts.URL, 1, time.Millisecond, httpSenderOptions{Retry: policy})
+			defer sender.Close()
+
+			err := sender.deliver([]byte(`{"ping_results":[]}`), policy.MaxAttempts)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("deliver() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if n := int(requests.Load()); n != tt.wantRequests {
+				t.Errorf("expected %d requests, received %d", tt.wantRequests, n)
+			}
+		})
+	}
+}
+
+func TestHTTPSenderAuth(t *testing.T) {
+	key := &authKey{ID: "k1", Secret: []byte("secret")}
+	data := []byte(`{"ping_results":[]}`)
+
+	nonces := map[string]bool{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		want := key.signature(r.Method, r.URL.RequestURI(), r.Header.Get(authTimestampHeader),
+			r.Header.Get(authNonceHeader), body)
+		if r.Header.Get(authKeyHeader) != key.ID || r.Header.Get(authSignatureHeader) != want {
+			t.Errorf("bad signature, headers %v", r.Header)
+		}
+		// каждая попытка подписывается заново
+		nonce := r.Header.Get(authNonceHeader)
+		if nonces[nonce] {
+			t.Errorf("nonce %q reused", nonce)
+		}
+		nonces[nonce] = true
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	policy := retryPolicy{MaxAttempts: 2, Timeout: time.Second, MinBackoff: time.Millisecond, MaxBackoff: time.Millisecond}
+	sender := newHTTPSender(ts.URL, 1, time.Millisecond, httpSenderOptions{Retry: policy, Auth: key})
+	defer sender.Close()
+
+	if err := sender.deliver(data, policy.MaxAttempts); err == nil {
+		t.Fatal("deliver() error = nil, want 503")
+	}
+	if len(nonces) != 2 {
+		t.Errorf("expected 2 requests, received %d", len(nonces))
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := retryPolicy{
+		MinBackoff: 100 * time.Millisecond,
+		MaxBackoff: time.Second,
+		Jitter:     0.5,
+	}
+	for n, want := range []time.Duration{100, 200, 400, 800, 1000, 1000} {
+		want *= time.Millisecond
+		for range 10 {
+			if d := policy.backoff(n + 1); d > want || d < want/2 {
+				t.Fatalf("backoff(%d) = %v, want in [%v, %v]", n+1, d, want/2, want)
+			}
+		}
+	}
+}
+
+// TestHTTPSenderOverflow проверяет политики переполнения очереди
+func TestHTTPSenderOverflow(t *testing.T) {
+	tests := []struct {
+		policy overflowPolicy
+		want   []int
+	}{
+		{overflowDropNewest, []int{1, 2}},
+		{overflowDropOldest, []int{2, 3}},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			// очередь без обработчика, чтобы она гарантированно переполнилась
+			sender := &httpSender{
+				c:        make(chan PingResult, 2),
+				overflow: tt.policy,
+			}
+
+			dropped := expvarInt(senderStats.Get(statResultsDropped))
+			for i := 1; i <= 3; i++ {
+				sender.Send(PingResult{HostID: i})
+			}
+			if n := expvarInt(senderStats.Get(statResultsDropped)) - dropped; n != 1 {
+				t.Errorf("expected 1 dropped result, received %d", n)
+			}
+
+			close(sender.c)
+			var got []int
+			for result := range sender.c {
+				got = append(got, result.HostID)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("expected queue %v, received %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func expvarInt(v expvar.Var) int64 {
+	if v == nil {
+		return 0
+	}
+	return v.(*expvar.Int).Value()
+}
+
 // batchRecorder записывает полученные батчи
 type batchRecorder struct {
 	mu      sync.Mutex
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"cmp"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	spoolSegmentExt  = ".seg"
+	spoolHeaderSize  = 4
+	maxSpoolSegments = 16
+	maxSegmentSize   = 4 << 20
+)
+
+// spool is a disk queue of batches that could not be delivered to the backend.
+//
+// Batches are appended to segment files as records (4 bytes big-endian length
+// followed by the data) and read back oldest first. When the spool exceeds its
+// size cap, the oldest segments are evicted. The read position is not persisted,
+// so after a restart the batches of a partially read segment are delivered again.
+type spool struct {
+	dir         string
+	maxSize     int64
+	segmentSize int64
+
+	mu       sync.Mutex
+	segments []spoolSegment // oldest first
+	size     int64
+	w        *os.File // the last segment, opened for writing in this run
+	r        *os.File // the first segment, opened for reading
+	readOff  int64
+	peekSize int64 // size of the record returned by Peek
+	evicted  int64 // number of evicted segments
+}
+
+type spoolSegment struct {
+	seq  uint64
+	size int64
+}
+
+func (seg spoolSegment) name() string {
+	return fmt.Sprintf("%016x%s", seg.seq, spoolSegmentExt)
+}
+
+// openSpool opens the spool in dir, creating the directory if needed. Segments
+// left by the previous run are kept to be read, new batches go to a new segment.
+func openSpool(dir string, maxSize int64) (*spool, error) {
+	if maxSize <= 0 {
+		return nil, fmt.Errorf("invalid spool max size %d", maxSize)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	sp := &spool{
+		dir:         dir,
+		maxSize:     maxSize,
+		segmentSize: max(min(maxSize/maxSpoolSegments, maxSegmentSize), 1),
+	}
+
+	for _, e := range entries {
+		name, ok := strings.CutSuffix(e.Name(), spoolSegmentExt)
+		if !ok || e.IsDir() {
+			continue
+		}
+		seq, err := strconv.ParseUint(name, 16, 64)
+		if err != nil {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			return nil, err
+		}
+		sp.segments = append(sp.segments, spoolSegment{seq: seq, size: info.Size()})
+		sp.size += info.Size()
+	}
+
+	slices.SortFunc(sp.segments, func(a, b spoolSegment) int {
+		return cmp.Compare(a.seq, b.seq)
+	})
+
+	return sp, nil
+}
+
+// Put appends the batch to the spool.
+func (sp *spool) Put(data []byte) error {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+
+	if sp.w == nil || sp.segments[len(sp.segments)-1].size >= sp.segmentSize {
+		if err := sp.newSegment(); err != nil {
+			return err
+		}
+	}
+
+	rec := make([]byte, spoolHeaderSize+len(data))
+	binary.BigEndian.PutUint32(rec, uint32(len(data)))
+	copy(rec[spoolHeaderSize:], data)
+
+	n, err := sp.w.Write(rec)
+	sp.segments[len(sp.segments)-1].size += int64(n)
+	sp.size += int64(n)
+	if err != nil {
+		// the record is damaged, do not append to this segment any more
+		sp.w.Close()
+		sp.w = nil
+		return err
+	}
+
+	sp.evict()
+	return nil
+}
+
+func (sp *spool) newSegment() error {
+	if sp.w != nil {
+		if err := sp.w.Close(); err != nil {
+			slog.Warn("can't close spool segment", "error", err)
+		}
+		sp.w = nil
+	}
+
+	seg := spoolSegment{seq: 1}
+	if n := len(sp.segments); n > 0 {
+		seg.seq = sp.segments[n-1].seq + 1
+	}
+
+	f, err := os.OpenFile(filepath.Join(sp.dir, seg.name()), os.O_CREATE|os.O_EXCL|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+
+	sp.w = f
+	sp.segments = append(sp.segments, seg)
+	return nil
+}
+
+// evict removes the oldest segments while the spool is over its size cap.
+// The segment being written is never removed.
+func (sp *spool) evict() {
+	for sp.size > sp.maxSize && len(sp.segments) > 1 {
+		seg := sp.segments[0]
+		slog.Warn("spool is full, evict oldest segment", "segment", seg.name(), "size", seg.size)
+		sp.evicted++
+		if err := sp.removeFirst(); err != nil {
+			slog.Error("can't remove spool segment", "error", err, "segment", seg.name())
+			return
+		}
+	}
+}
+
+func (sp *spool) removeFirst() error {
+	if sp.r != nil {
+		sp.r.Close()
+		sp.r = nil
+	}
+	sp.readOff = 0
+	sp.peekSize = 0
+	if sp.w != nil && len(sp.segments) == 1 {
+		sp.w.Close()
+		sp.w = nil
+	}
+
+	seg := sp.segments[0]
+	sp.segments = sp.segments[1:]
+	sp.size -= seg.size
+
+	return os.Remove(filepath.Join(sp.dir, seg.name()))
+}
+
+// Peek returns the oldest batch without removing it. ok is false if the spool is empty.
+func (sp *spool) Peek() (data []byte, ok bool, err error) {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+
+	for len(sp.segments) > 0 {
+		seg := sp.segments[0]
+		writing := sp.w != nil && len(sp.segments) == 1
+
+		if sp.r == nil {
+			sp.r, err = os.Open(filepath.Join(sp.dir, seg.name()))
+			if err != nil {
+				return nil, false, err
+			}
+		}
+
+		data, err := sp.readRecord(seg.size)
+		if err == nil {
+			return data, true, nil
+		}
+		if writing && err == io.EOF {
+			return nil, false, nil
+		}
+		if err != io.EOF && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, false, err
+		}
+
+		// the segment is read up or its tail is damaged by a crash
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			slog.Warn("spool segment is truncated", "segment", seg.name(), "offset", sp.readOff)
+		}
+		if err := sp.removeFirst(); err != nil {
+			return nil, false, err
+		}
+	}
+
+	return nil, false, nil
+}
+
+func (sp *spool) readRecord(segSize int64) ([]byte, error) {
+	if sp.readOff >= segSize {
+		return nil, io.EOF
+	}
+
+	var header [spoolHeaderSize]byte
+	if _, err := sp.r.ReadAt(header[:], sp.readOff); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+
+	// a damaged length must not allocate more than the segment has
+	n := int64(binary.BigEndian.Uint32(header[:]))
+	if n > segSize-sp.readOff-spoolHeaderSize {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	data := make([]byte, n)
+	if _, err := sp.r.ReadAt(data, sp.readOff+spoolHeaderSize); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+
+	sp.peekSize = spoolHeaderSize + int64(len(data))
+	return data, nil
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// Pop removes the batch returned by the last Peek.
+func (sp *spool) Pop() error {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+
+	if sp.peekSize == 0 {
+		return nil
+	}
+	sp.readOff += sp.peekSize
+	sp.peekSize = 0
+
+	writing := sp.w != nil && len(sp.segments) == 1
+	if !writing && sp.readOff >= sp.segments[0].size {
+		return sp.removeFirst()
+	}
+	return nil
+}
+
+// Size returns the spool size in bytes and the number of evicted segments.
+func (sp *spool) Size() (size, evicted int64) {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	return sp.size, sp.evicted
+}
+
+// Empty reports whether there is nothing to read.
+func (sp *spool) Empty() bool {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	return len(sp.segments) == 0 ||
+		len(sp.segments) == 1 && sp.w != nil && sp.readOff >= sp.segments[0].size
+}
+
+func (sp *spool) Close() error {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+
+	var errs []error
+	if sp.r != nil {
+		errs = append(errs, sp.r.Close())
+		sp.r = nil
+	}
+	if sp.w != nil {
+		errs = append(errs, sp.w.Close())
+		sp.w = nil
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestSpool(t *testing.T) {
+	t.Run("batches are read in order", func(t *testing.T) {
+		sp, err := openSpool(t.TempDir(), 1<<20)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer sp.Close()
+
+		for i := range 3 {
+			mustPut(t, sp, fmt.Sprint(i))
+		}
+		for i := range 3 {
+			mustPop(t, sp, fmt.Sprint(i))
+		}
+		mustBeEmpty(t, sp)
+
+		// запись после полного чтения
+		mustPut(t, sp, "3")
+		mustPop(t, sp, "3")
+		mustBeEmpty(t, sp)
+	})
+
+	t.Run("peek without pop returns the same batch", func(t *testing.T) {
+		sp, err := openSpool(t.TempDir(), 1<<20)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer sp.Close()
+
+		mustPut(t, sp, "0")
+		mustPut(t, sp, "1")
+		for range 2 {
+			data, ok, err := sp.Peek()
+			if err != nil || !ok || string(data) != "0" {
+				t.Fatalf("Peek() = %q, %v, %v", data, ok, err)
+			}
+		}
+		mustPop(t, sp, "0")
+		mustPop(t, sp, "1")
+	})
+
+	t.Run("batches survive reopen", func(t *testing.T) {
+		dir := t.TempDir()
+
+		sp, err := openSpool(dir, 1<<20)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := range 3 {
+			mustPut(t, sp, fmt.Sprint(i))
+		}
+		mustPop(t, sp, "0")
+		sp.Close()
+
+		sp, err = openSpool(dir, 1<<20)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer sp.Close()
+
+		// позиция чтения не сохраняется, прочитанный батч сегмента будет прочитан повторно
+		mustPut(t, sp, "3")
+		for i := range 4 {
+			mustPop(t, sp, fmt.Sprint(i))
+		}
+		mustBeEmpty(t, sp)
+
+		entries, _ := os.ReadDir(dir)
+		if len(entries) != 1 {
+			t.Errorf("expected 1 segment left, received %d", len(entries))
+		}
+	})
+
+	t.Run("oldest segments are evicted", func(t *testing.T) {
+		const maxSize = maxSpoolSegments * 30 // 3 батча в сегменте
+		sp, err := openSpool(t.TempDir(), maxSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer sp.Close()
+
+		for i := range 100 {
+			mustPut(t, sp, fmt.Sprintf("%06d", i)) // 4+6 = 10 байт
+		}
+
+		size, evicted := sp.Size()
+		if size > maxSize || evicted == 0 {
+			t.Fatalf("Size() = %d, %d", size, evicted)
+		}
+
+		// остались самые новые батчи
+		data, ok, err := sp.Peek()
+		if err != nil || !ok {
+			t.Fatalf("Peek() = %q, %v, %v", data, ok, err)
+		}
+		first := 100 - int(size)/10
+		if want := fmt.Sprintf("%06d", first); string(data) != want {
+			t.Fatalf("expected oldest batch %q, received %q", want, data)
+		}
+		for i := first; i < 100; i++ {
+			mustPop(t, sp, fmt.Sprintf("%06d", i))
+		}
+		mustBeEmpty(t, sp)
+	})
+
+	t.Run("truncated segment is skipped", func(t *testing.T) {
+		dir := t.TempDir()
+
+		sp, err := openSpool(dir, 1<<20)
+		if err != nil {
+			t.Fatal(err)
+		}
+		mustPut(t, sp, "0")
+		mustPut(t, sp, "1")
+		sp.Close()
+
+		name := dir + "/" + spoolSegment{seq: 1}.name()
+		info, _ := os.Stat(name)
+		if err := os.Truncate(name, info.Size()-1); err != nil {
+			t.Fatal(err)
+		}
+
+		sp, err = openSpool(dir, 1<<20)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer sp.Close()
+
+		mustPop(t, sp, "0")
+		mustBeEmpty(t, sp)
+	})
+
+	t.Run("damaged record length is not trusted", func(t *testing.T) {
+		dir := t.TempDir()
+
+		// длина записи больше сегмента, следующий сегмент читается
+		name := dir + "/" + spoolSegment{seq: 1}.name()
+		if err := os.WriteFile(name, []byte{0xff, 0xff, 0xff, 0xff, '0'}, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		sp, err := openSpool(dir, 1<<20)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer sp.Close()
+
+		mustPut(t, sp, "1")
+		mustPop(t, sp, "1")
+		mustBeEmpty(t, sp)
+	})
+}
+
+func mustPut(t *testing.T, sp *spool, data string) {
+	t.Helper()
+	if err := sp.Put([]byte(data)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func mustPop(t *testing.T, sp *spool, want string) {
+	t.Helper()
+	data, ok, err := sp.Peek()
+	if err != nil || !ok || string(data) != want {
+		t.Fatalf("Peek() = %q, %v, %v; want %q", data, ok, err, want)
+	}
+	if err := sp.Pop(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func mustBeEmpty(t *testing.T, sp *spool) {
+	t.Helper()
+	if data, ok, err := sp.Peek(); err != nil || ok {
+		t.Fatalf("Peek() = %q, %v, %v; want empty", data, ok, err)
+	}
+	if !sp.Empty() {
+		t.Fatal("Empty() = false")
+	}
+}
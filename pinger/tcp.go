@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"syscall"
+	"time"
+)
+
+// connectOnce opens a TCP connection to addr (host:port) and reports the connect
+// latency. A refused connection and a timeout are reported as failures with
+// different reasons.
+func connectOnce(ctx context.Context, host Host, addr string, timeout time.Duration, snd sender) {
+	hostname, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		snd.Send(failedResult(host, probeTCP, "", time.Now(), err.Error()))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	ips, err := net.DefaultResolver.LookupIPAddr(ctx, hostname)
+	if ctx.Err() == context.Canceled {
+		return
+	}
+	if err != nil {
+		snd.Send(failedResult(host, probeTCP, "", time.Now(), "resolve: "+err.Error()))
+		return
+	}
+	ip := ips[0].IP.String()
+
+	var dialer net.Dialer
+	start := time.Now()
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(ip, port))
+	rtt := time.Since(start)
+	if ctx.Err() == context.Canceled {
+		return
+	}
+	if err != nil {
+		snd.Send(failedResult(host, probeTCP, ip, start, errorReason("connect", err)))
+		return
+	}
+	conn.Close()
+
+	snd.Send(PingResult{
+		HostID:  host.ID,
+		Probe:   probeTCP,
+		IP:      ip,
+		Time:    time.Now(),
+		Rtt:     rtt,
+		Success: true,
+	})
+}
+
+// errorReason tells a refused connection and a timeout from other errors.
+func errorReason(op string, err error) string {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "refused"
+	case errors.Is(err, context.DeadlineExceeded),
+		errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	}
+	return op + ": " + err.Error()
+}